@@ -0,0 +1,57 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+*/
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sempr/hustoj-go/internal/daemon"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ctlOJHome   string
+	ctlInstance string
+	ctlSite     string
+	ctlSocket   string
+)
+
+// ctlCmd represents the ctl command
+var ctlCmd = &cobra.Command{
+	Use:   "ctl COMMAND [ARGS]",
+	Short: "Control a running judge daemon",
+	Long: `Send a command to the judge daemon over its control socket.
+
+Commands:
+  status               show slots, running solutions, uptime and config
+  pause                stop taking new jobs, keep judging the running ones
+  resume               take new jobs again
+  drain                stop taking new jobs and exit once the running ones finish
+  set running N        change the number of slots (OJ_RUNNING)
+  kill SOLUTION_ID     stop a judgement and mark it as a system error
+
+A daemon serving several sites applies status, pause, resume and drain to
+all of them unless --site is given; set and kill need --site.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		socket := ctlSocket
+		if socket == "" {
+			socket = daemon.CtlSocketPath(ctlOJHome, ctlInstance)
+		}
+		if err := daemon.Ctl(socket, ctlSite, args, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ctlCmd)
+
+	ctlCmd.Flags().StringVar(&ctlOJHome, "ojhome", "/home/judge", "online judge home")
+	ctlCmd.Flags().StringVar(&ctlInstance, "instance", "", "instance name of the daemon")
+	ctlCmd.Flags().StringVar(&ctlSite, "site", "", "site of a multi-site daemon (default all sites)")
+	ctlCmd.Flags().StringVar(&ctlSocket, "socket", "", "control socket (default <ojhome>/etc/judged-go[-<instance>].sock)")
+}
@@ -36,6 +36,7 @@ func init() {
 	// is called directly, e.g.:
 	// daemonCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	daemonCmd.Flags().StringVar(&daemonArgs.OJHome, "ojhome", "/home/judge", "online judge home")
+	daemonCmd.Flags().StringVar(&daemonArgs.Instance, "instance", "", "instance name, to run several daemons on one host")
 	daemonCmd.Flags().BoolVar(&daemonArgs.Debug, "debug", false, "debug?")
 	daemonCmd.Flags().BoolVar(&daemonArgs.Once, "once", false, "run once")
 }
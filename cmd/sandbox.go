@@ -47,5 +47,7 @@ func init() {
 	sandboxCmd.Flags().IntVar(&sandboxCfg.TimeLimit, "time", 1000, "time limit in ms")
 	sandboxCmd.Flags().IntVar(&sandboxCfg.MemoryLimit, "memory", 256<<10, "memory limit in KB")
 	sandboxCmd.Flags().IntVar(&sandboxCfg.SolutionId, "sid", 0, "solution ID")
+	sandboxCmd.Flags().StringVar(&sandboxCfg.CPUs, "cpus", "", "CPUs to pin the program to, e.g. 3")
+	sandboxCmd.Flags().StringVar(&sandboxCfg.Instance, "instance", "", "daemon instance and site whose cgroup subtree the program runs in")
 
 }
@@ -0,0 +1,143 @@
+// Package e2e runs the whole judge, daemon, client and sandbox, against a
+// SQLite database. It needs root and the language rootfs of
+// extra/build_rootfs.sh, so it only runs with HUSTOJ_E2E=1:
+//
+//	sudo HUSTOJ_E2E=1 go test ./e2e/
+//
+// HUSTOJ_E2E_LANGS points to the lang.toml directory to use, by default
+// extra/etc/langs.
+package e2e
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/constants"
+	"github.com/sempr/hustoj-go/pkg/repository"
+)
+
+const judgeConf = `OJ_DB_DRIVER=sqlite
+OJ_LANG_SET=0
+OJ_RUNNING=2
+OJ_SLEEP_TIME=1
+OJ_JUDGER_NAME=e2e
+`
+
+// ojHome lays out a judge home with problem 1000 (A+B) and the C language.
+func ojHome(t *testing.T) string {
+	home := t.TempDir()
+	langs := os.Getenv("HUSTOJ_E2E_LANGS")
+	if langs == "" {
+		langs = filepath.Join("..", "extra", "etc", "langs")
+	}
+	files := map[string]string{
+		"etc/judge.conf":        judgeConf,
+		"etc/langs/all.toml":    readFile(t, filepath.Join(langs, "all.toml")),
+		"etc/langs/0.lang.toml": readFile(t, filepath.Join(langs, "0.lang.toml")),
+		"data/1000/1.in":        "1 2\n",
+		"data/1000/1.out":       "3\n",
+		"data/1000/2.in":        "-5 5\n",
+		"data/1000/2.out":       "0\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(home, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return home
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func submit(t *testing.T, db *sql.DB, solutionID int, source string) {
+	t.Helper()
+	if _, err := db.Exec("INSERT INTO solution (solution_id, problem_id, user_id, language, code_length) VALUES (?, 1000, 'alice', 0, ?)",
+		solutionID, len(source)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO source_code VALUES (?, ?)", solutionID, source); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJudgeOnce(t *testing.T) {
+	if os.Getenv("HUSTOJ_E2E") != "1" {
+		t.Skip("set HUSTOJ_E2E=1 to run the end-to-end tests")
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("the sandbox needs root")
+	}
+
+	bin := filepath.Join(t.TempDir(), "hustoj-go")
+	if out, err := exec.Command("go", "build", "-o", bin, "github.com/sempr/hustoj-go").CombinedOutput(); err != nil {
+		t.Fatalf("build failed: %v\n%s", err, out)
+	}
+
+	home := ojHome(t)
+	db, err := repository.OpenSQLite(filepath.Join(home, "hustoj.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("INSERT INTO problem (problem_id, title, time_limit, memory_limit) VALUES (1000, 'A+B', 1, 128)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO users (user_id) VALUES ('alice')"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]int{
+		1: constants.OJ_AC,
+		2: constants.OJ_WA,
+		3: constants.OJ_CE,
+	}
+	submit(t, db, 1, "#include <stdio.h>\nint main(){int a,b;scanf(\"%d%d\",&a,&b);printf(\"%d\\n\",a+b);return 0;}\n")
+	submit(t, db, 2, "#include <stdio.h>\nint main(){int a,b;scanf(\"%d%d\",&a,&b);printf(\"%d\\n\",a-b);return 0;}\n")
+	submit(t, db, 3, "int main(){ return }\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, bin, "daemon", "--ojhome", home, "--debug", "--once").CombinedOutput()
+	if err != nil {
+		t.Fatalf("daemon failed: %v\n%s", err, out)
+	}
+
+	for solutionID, result := range want {
+		var got int
+		if err := db.QueryRow("SELECT result FROM solution WHERE solution_id=?", solutionID).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != result {
+			t.Errorf("solution %d: result = %d; want %d", solutionID, got, result)
+		}
+	}
+
+	var ceinfo string
+	if err := db.QueryRow("SELECT error FROM compileinfo WHERE solution_id=3").Scan(&ceinfo); err != nil || ceinfo == "" {
+		t.Errorf("compile error of solution 3 not recorded: %q, %v", ceinfo, err)
+	}
+	var solved int
+	db.QueryRow("SELECT solved FROM users WHERE user_id='alice'").Scan(&solved)
+	if solved != 1 {
+		t.Errorf("alice solved %d problems; want 1", solved)
+	}
+	if t.Failed() {
+		t.Logf("daemon output:\n%s", out)
+	}
+}
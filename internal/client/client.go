@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
@@ -9,7 +10,11 @@ import (
 	"strings"
 
 	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/constants"
+	"github.com/sempr/hustoj-go/pkg/events"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
 	"github.com/sempr/hustoj-go/pkg/language"
+	"github.com/sempr/hustoj-go/pkg/models"
 	"github.com/sempr/hustoj-go/pkg/repository"
 )
 
@@ -29,21 +34,35 @@ type RunConfig struct {
 
 type JudgeClient struct {
 	config      *config.JudgeConfig
-	db          *repository.Database
+	db          interfaces.Database
 	langManager *language.Manager
 	solutionID  int
 	runnerID    string
 	debug       bool
+	ownsDB      bool
+	log         *slog.Logger
+	ctx         context.Context // Cancels sandbox processes when judging is aborted
+	cpus        string          // CPUs the sandbox is pinned to, empty if not pinned
+	events      events.Sink
+	progress    *progressReporter    // Set while tests are running
+	solution    *repository.Solution // Set once the submission has been read
+	report      models.JudgeReport
 }
 
 func NewJudgeClient(solutionID int, runnerID, homeDir string, debug bool) (*JudgeClient, error) {
-	cfg, err := config.LoadJudgeConf(homeDir)
+	instance, site := os.Getenv(InstanceEnv), os.Getenv(SiteEnv)
+	for _, name := range []string{instance, site} {
+		if err := config.ValidateInstance(name); err != nil {
+			return nil, err
+		}
+	}
+	cfg, err := config.LoadSiteConf(homeDir, instance, site)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
 	cfg.Debug = debug
 
-	db, err := repository.NewDatabase(&cfg.Database)
+	db, err := repository.OpenWithRetry(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
@@ -53,6 +72,8 @@ func NewJudgeClient(solutionID int, runnerID, homeDir string, debug bool) (*Judg
 		return nil, fmt.Errorf("failed to initialize language manager: %w", err)
 	}
 
+	slog.SetDefault(withSite(slog.Default().With("solution_id", solutionID), cfg))
+
 	client := &JudgeClient{
 		config:      cfg,
 		db:          db,
@@ -60,33 +81,95 @@ func NewJudgeClient(solutionID int, runnerID, homeDir string, debug bool) (*Judg
 		solutionID:  solutionID,
 		runnerID:    runnerID,
 		debug:       debug,
+		ownsDB:      true,
+		log:         slog.Default(),
+		ctx:         context.Background(),
+		events:      events.New(cfg),
 	}
 
-	slog.SetDefault(slog.Default().With("solution_id", solutionID))
-
 	return client, nil
 }
 
+// NewSharedJudgeClient creates a client that judges inside the calling
+// process, reusing a database pool, language manager and event sink owned
+// by the caller. Close does not close the shared database or sink.
+func NewSharedJudgeClient(cfg *config.JudgeConfig, db interfaces.Database, langManager *language.Manager, sink events.Sink, solutionID int, runnerID string) *JudgeClient {
+	if sink == nil {
+		sink = events.Nop{}
+	}
+	return &JudgeClient{
+		config:      cfg,
+		db:          repository.WithRetry(db),
+		langManager: langManager,
+		solutionID:  solutionID,
+		runnerID:    runnerID,
+		log:         withSite(slog.Default().With("solution_id", solutionID), cfg),
+		ctx:         context.Background(),
+		events:      sink,
+	}
+}
+
+// withSite adds the site of a multi-site daemon to the log lines of a client.
+func withSite(log *slog.Logger, cfg *config.JudgeConfig) *slog.Logger {
+	if cfg.Site == "" {
+		return log
+	}
+	return log.With("site", cfg.Site)
+}
+
 func (jc *JudgeClient) Close() error {
+	if !jc.ownsDB {
+		return nil
+	}
+	jc.events.Close()
 	if jc.db != nil {
 		return jc.db.Close()
 	}
 	return nil
 }
 
+// emit publishes an event about the submission being judged.
+func (jc *JudgeClient) emit(e events.Event) {
+	e.SolutionID = jc.solutionID
+	e.Judger = jc.config.Judger
+	if s := jc.solution; s != nil {
+		e.ProblemID, e.UserID, e.ContestID, e.Language = s.ProblemID, s.UserID, s.ContestID, s.Language
+	}
+	if e.Result != 0 {
+		e.ResultName = constants.GetOJResultName(e.Result)
+	}
+	jc.events.Publish(e)
+}
+
+// Report returns the summary of the last judgement for the daemon.
+func (jc *JudgeClient) Report() models.JudgeReport {
+	return jc.report
+}
+
 func (jc *JudgeClient) updateSolutionStatus(status int) error {
-	return jc.db.UpdateSolution(jc.solutionID, status, 0, 0, 0.0)
+	return jc.updateSolution(status, 0, 0, 0.0)
+}
+
+// updateSolution writes a verdict and records final results in the report.
+func (jc *JudgeClient) updateSolution(result, timeUsed, memoryUsed int, passRate float64) error {
+	if err := jc.db.UpdateSolution(jc.solutionID, result, timeUsed, memoryUsed, passRate); err != nil {
+		return err
+	}
+	if result >= constants.OJ_AC {
+		jc.report.Result = result
+	}
+	return nil
 }
 
 func (jc *JudgeClient) updateUserStats(userID string) {
 	if err := jc.db.UpdateUserStats(userID); err != nil {
-		slog.Warn("Failed to update user stats", "user_id", userID, "error", err)
+		jc.log.Warn("Failed to update user stats", "user_id", userID, "error", err)
 	}
 }
 
 func (jc *JudgeClient) updateProblemStats(problemID, contestID int) {
 	if err := jc.db.UpdateProblemStats(problemID, contestID); err != nil {
-		slog.Warn("Failed to update problem stats", "problem_id", problemID, "contest_id", contestID, "error", err)
+		jc.log.Warn("Failed to update problem stats", "problem_id", problemID, "contest_id", contestID, "error", err)
 	}
 }
 
@@ -3,7 +3,6 @@ package client
 import (
 	"encoding/json"
 	"fmt"
-	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -16,19 +15,29 @@ import (
 func (jc *JudgeClient) compile(langID int, rootfs string, langConfig *language.LangConfig) *models.SandboxOutput {
 	os.Chmod(filepath.Join(rootfs, "code"), 0777)
 	defer os.Chmod(filepath.Join(rootfs, "code"), 0755)
+	jc.log.Info("Starting compilation", "language", langID, "work_dir", rootfs)
+	output := jc.runTool(rootfs, langConfig.Cmd.Compile, langConfig.Cmd.Env, "compile")
+	jc.log.Debug("Compilation output", "output", output)
+	return output
+}
+
+// runTool runs a command of the language, such as its compiler, in the
+// sandbox with the limits of a compilation. what names the command in
+// error messages.
+func (jc *JudgeClient) runTool(rootfs, command string, env []string, what string) *models.SandboxOutput {
 	selfName, _ := os.Executable()
-	cmd := exec.Command(selfName,
+	cmd := exec.CommandContext(jc.ctx, selfName, jc.sandboxArgs(
 		"sandbox",
 		fmt.Sprintf("--rootfs=%s", rootfs),
-		fmt.Sprintf("--cmd=%s", langConfig.Cmd.Compile),
+		fmt.Sprintf("--cmd=%s", command),
 		fmt.Sprintf("--time=%d", 3000),
 		fmt.Sprintf("--memory=%d", 256<<10),
 		fmt.Sprintf("--sid=%d", jc.solutionID),
 		"--cwd=/code",
-	)
+	)...)
 
-	if len(langConfig.Cmd.Env) > 0 {
-		cmd.Env = append(cmd.Env, langConfig.Cmd.Env...)
+	if len(env) > 0 {
+		cmd.Env = append(cmd.Env, env...)
 	}
 
 	cmd.Stdout = os.Stdout
@@ -38,17 +47,16 @@ func (jc *JudgeClient) compile(langID int, rootfs string, langConfig *language.L
 	if err != nil {
 		return &models.SandboxOutput{
 			UserStatus:     constants.OJ_SE,
-			CombinedOutput: "failed to create pipe for compile",
+			CombinedOutput: fmt.Sprintf("failed to create pipe for %s", what),
 		}
 	}
 
 	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
-	slog.Info("Starting compilation", "language", langID, "work_dir", rootfs)
 
 	if err := cmd.Start(); err != nil {
 		return &models.SandboxOutput{
 			UserStatus:     constants.OJ_SE,
-			CombinedOutput: "failed to start compile command",
+			CombinedOutput: fmt.Sprintf("failed to start %s command", what),
 		}
 	}
 
@@ -59,10 +67,8 @@ func (jc *JudgeClient) compile(langID int, rootfs string, langConfig *language.L
 	if err := json.NewDecoder(r).Decode(&output); err != nil {
 		return &models.SandboxOutput{
 			UserStatus:     constants.OJ_SE,
-			CombinedOutput: fmt.Sprintf("failed to decode compile output: %v", err),
+			CombinedOutput: fmt.Sprintf("failed to decode %s output: %v", what, err),
 		}
 	}
-
-	slog.Debug("Compilation output", "output", output)
 	return &output
 }
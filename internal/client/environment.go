@@ -1,17 +1,24 @@
 package client
 
 import (
+	"bufio"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/cpuset"
 	"github.com/sempr/hustoj-go/pkg/language"
 	"golang.org/x/sys/unix"
 )
 
 func (jc *JudgeClient) setupWorkEnvironment(langConfig *language.LangConfig) (string, error) {
-	workBaseDir := filepath.Join(jc.config.OJHome, "run"+jc.runnerID)
+	workBaseDir := filepath.Join(jc.config.OJHome, runDirName(jc.config.Namespace(), jc.runnerID))
 
 	for _, dir := range []string{"rootfs", "tmp"} {
 		if err := os.MkdirAll(filepath.Join(workBaseDir, dir), 0755); err != nil {
@@ -46,21 +53,137 @@ func (jc *JudgeClient) setupWorkEnvironment(langConfig *language.LangConfig) (st
 
 func (jc *JudgeClient) cleanupWorkEnvironment(rootfs string) {
 	if jc.debug {
-		slog.Info("Keeping rootfs due to debug option", "rootfs", rootfs)
+		jc.log.Info("Keeping rootfs due to debug option", "rootfs", rootfs)
 		return
 	}
 
 	if err := unix.Unmount(rootfs, 0); err != nil {
-		slog.Warn("Failed to unmount overlay", "error", err)
+		jc.log.Warn("Failed to unmount overlay", "error", err)
 	}
 
 	tmpfsDir := filepath.Join(filepath.Dir(rootfs), "tmp")
 	if err := unix.Unmount(tmpfsDir, 0); err != nil {
-		slog.Warn("Failed to unmount tmpfs", "error", err)
+		jc.log.Warn("Failed to unmount tmpfs", "error", err)
 	}
 
 	workBaseDir := filepath.Dir(rootfs)
 	if err := os.RemoveAll(workBaseDir); err != nil {
-		slog.Warn("Failed to remove work directory", "path", workBaseDir, "error", err)
+		jc.log.Warn("Failed to remove work directory", "path", workBaseDir, "error", err)
 	}
 }
+
+// runnerCPUs returns the CPU this runner slot is pinned to, or "" when
+// OJ_CPU_PIN is off or the slot cannot be pinned.
+func (jc *JudgeClient) runnerCPUs() string {
+	if !jc.config.CPU.Pin {
+		return ""
+	}
+	slot, err := strconv.Atoi(jc.runnerID)
+	if err != nil {
+		jc.log.Warn("Runner id is not a slot number, not pinning", "runner_id", jc.runnerID)
+		return ""
+	}
+	plan, err := cpuset.NewPlan(&jc.config.CPU)
+	if err != nil {
+		jc.log.Warn("Could not plan CPU pinning, running unpinned", "error", err)
+		return ""
+	}
+	return strconv.Itoa(plan.Runner(slot))
+}
+
+// sandboxArgs adds the options every sandbox run of this client shares.
+func (jc *JudgeClient) sandboxArgs(args ...string) []string {
+	if jc.cpus != "" {
+		args = append(args, "--cpus="+jc.cpus)
+	}
+	if ns := jc.config.Namespace(); ns != "" {
+		args = append(args, "--instance="+ns)
+	}
+	return args
+}
+
+// runDirName returns the work directory of a runner: run<N> for the default
+// instance and run<N>-<namespace> otherwise, see JudgeConfig.Namespace.
+func runDirName(namespace, runnerID string) string {
+	return "run" + runnerID + config.InstanceSuffix(namespace)
+}
+
+// CleanupRunDirs unmounts and removes runner work directories of namespace
+// under ojHome that were left behind by a crashed judgement or language
+// probe. It must not be called while any client of the namespace is
+// running.
+func CleanupRunDirs(ojHome, namespace string) error {
+	pattern := regexp.MustCompile(`^run(probe)?\d+` + regexp.QuoteMeta(config.InstanceSuffix(namespace)) + `$`)
+	return cleanupRunDirs(ojHome, pattern.MatchString)
+}
+
+// CleanupRunDir unmounts and removes the work directory of a single runner
+// whose client was killed. Other runners are left alone.
+func CleanupRunDir(ojHome, namespace, runnerID string) error {
+	name := runDirName(namespace, runnerID)
+	return cleanupRunDirs(ojHome, func(dir string) bool { return dir == name })
+}
+
+func cleanupRunDirs(ojHome string, match func(runDir string) bool) error {
+	mounts, err := mountPointsUnder(ojHome)
+	if err != nil {
+		return err
+	}
+
+	// Unmount the deepest paths first: the overlay sits on top of the tmpfs.
+	sort.Slice(mounts, func(i, j int) bool { return len(mounts[i]) > len(mounts[j]) })
+	busy := make(map[string]bool)
+	for _, mnt := range mounts {
+		rel, _ := filepath.Rel(ojHome, mnt)
+		runDir := strings.Split(rel, string(filepath.Separator))[0]
+		if !match(runDir) {
+			continue
+		}
+		if err := unix.Unmount(mnt, unix.MNT_DETACH); err != nil {
+			slog.Warn("Failed to unmount leftover mount", "path", mnt, "error", err)
+			busy[runDir] = true
+			continue
+		}
+		slog.Info("Unmounted leftover mount", "path", mnt)
+	}
+
+	entries, err := os.ReadDir(ojHome)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !match(entry.Name()) || busy[entry.Name()] {
+			continue
+		}
+		path := filepath.Join(ojHome, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			slog.Warn("Failed to remove leftover work directory", "path", path, "error", err)
+		}
+	}
+	return nil
+}
+
+// mountPointsUnder lists mount points below dir from /proc/self/mountinfo.
+func mountPointsUnder(dir string) ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	var mounts []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		// Field 5 is the mount point with spaces escaped as \040.
+		mnt := strings.ReplaceAll(fields[4], "\\040", " ")
+		if strings.HasPrefix(mnt, prefix) {
+			mounts = append(mounts, mnt)
+		}
+	}
+	return mounts, scanner.Err()
+}
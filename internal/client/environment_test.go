@@ -0,0 +1,77 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCleanupRunDirs(t *testing.T) {
+	home := t.TempDir()
+	for _, dir := range []string{"run0/rootfs", "run12/tmp", "runprobe3/rootfs", "run3-b/rootfs", "runprobe3-b/rootfs", "data/1000", "runtime", "etc"} {
+		if err := os.MkdirAll(filepath.Join(home, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := CleanupRunDirs(home, ""); err != nil {
+		t.Fatalf("CleanupRunDirs: %v", err)
+	}
+
+	for _, dir := range []string{"run0", "run12", "runprobe3"} {
+		if _, err := os.Stat(filepath.Join(home, dir)); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", dir)
+		}
+	}
+	for _, dir := range []string{"run3-b", "runprobe3-b", "data", "runtime", "etc"} {
+		if _, err := os.Stat(filepath.Join(home, dir)); err != nil {
+			t.Errorf("%s should be kept: %v", dir, err)
+		}
+	}
+}
+
+func TestCleanupRunDirKeepsOtherRunners(t *testing.T) {
+	home := t.TempDir()
+	for _, dir := range []string{"run1/rootfs", "run10/rootfs", "run2/tmp"} {
+		if err := os.MkdirAll(filepath.Join(home, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := CleanupRunDir(home, "", "1"); err != nil {
+		t.Fatalf("CleanupRunDir: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(home, "run1")); !os.IsNotExist(err) {
+		t.Error("run1 was not removed")
+	}
+	for _, dir := range []string{"run10", "run2"} {
+		if _, err := os.Stat(filepath.Join(home, dir)); err != nil {
+			t.Errorf("%s should be kept: %v", dir, err)
+		}
+	}
+}
+
+func TestCleanupRunDirsOfInstance(t *testing.T) {
+	home := t.TempDir()
+	for _, dir := range []string{"run0/rootfs", "run0-a/rootfs", "run1-a/tmp", "run1-ab/tmp"} {
+		if err := os.MkdirAll(filepath.Join(home, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := CleanupRunDirs(home, "a"); err != nil {
+		t.Fatalf("CleanupRunDirs: %v", err)
+	}
+
+	for _, dir := range []string{"run0-a", "run1-a"} {
+		if _, err := os.Stat(filepath.Join(home, dir)); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", dir)
+		}
+	}
+	for _, dir := range []string{"run0", "run1-ab"} {
+		if _, err := os.Stat(filepath.Join(home, dir)); err != nil {
+			t.Errorf("%s should be kept: %v", dir, err)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -32,7 +31,7 @@ func (jc *JudgeClient) runAndCompare(config RunConfig) (int, int, int) {
 
 	langConfig, err := jc.langManager.GetLanguageConfig(config.Lang)
 	if err != nil {
-		slog.Error("Failed to get language config", "error", err)
+		jc.log.Error("Failed to get language config", "error", err)
 		return constants.OJ_SE, 0, 0
 	}
 
@@ -54,7 +53,7 @@ func (jc *JudgeClient) runAndCompare(config RunConfig) (int, int, int) {
 	}
 
 	selfName, _ := os.Executable()
-	cmd := exec.Command(selfName, runArgs...)
+	cmd := exec.CommandContext(jc.ctx, selfName, jc.sandboxArgs(runArgs...)...)
 	if len(langConfig.Cmd.Env) > 0 {
 		cmd.Env = append(cmd.Env, langConfig.Cmd.Env...)
 	}
@@ -68,7 +67,7 @@ func (jc *JudgeClient) runAndCompare(config RunConfig) (int, int, int) {
 	}
 
 	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
-	slog.Info("Starting execution", "language", config.Lang, "work_dir", config.Rootdir)
+	jc.log.Info("Starting execution", "language", config.Lang, "work_dir", config.Rootdir)
 
 	if err := cmd.Start(); err != nil {
 		return constants.OJ_SE, 0, 0
@@ -79,12 +78,13 @@ func (jc *JudgeClient) runAndCompare(config RunConfig) (int, int, int) {
 
 	var output models.SandboxOutput
 	if err := json.NewDecoder(r).Decode(&output); err != nil {
-		slog.Error("Failed to decode run output", "error", err)
+		jc.log.Error("Failed to decode run output", "error", err)
 		return constants.OJ_SE, 0, 0
 	}
 
 	if output.SystemError {
-		slog.Error("Execution system error", "output", output.CombinedOutput)
+		jc.report.SystemErrors++
+		jc.log.Error("Execution system error", "output", output.CombinedOutput)
 		return constants.OJ_SE, 0, 0
 	}
 
@@ -122,7 +122,7 @@ func (jc *JudgeClient) runAndCompare(config RunConfig) (int, int, int) {
 	}
 
 	if err != nil {
-		slog.Info("re result? ", "err", err)
+		jc.log.Info("re result? ", "err", err)
 		result = constants.OJ_RE
 	}
 
@@ -166,19 +166,19 @@ func (jc *JudgeClient) handleSpecialJudge(config RunConfig) (int, int, int) {
 
 	r, w, err := os.Pipe()
 	if err != nil {
-		slog.Error("Failed to create pipe for special judge", "error", err)
+		jc.log.Error("Failed to create pipe for special judge", "error", err)
 		return constants.OJ_SE, 0, 0
 	}
 	defer r.Close()
 
 	selfName, _ := os.Executable()
-	cmd := exec.Command(selfName, runArgs...)
+	cmd := exec.CommandContext(jc.ctx, selfName, jc.sandboxArgs(runArgs...)...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.ExtraFiles = []*os.File{w}
 
 	if err := cmd.Start(); err != nil {
-		slog.Error("Failed to start special judge", "error", err)
+		jc.log.Error("Failed to start special judge", "error", err)
 		return constants.OJ_SE, 0, 0
 	}
 
@@ -187,17 +187,17 @@ func (jc *JudgeClient) handleSpecialJudge(config RunConfig) (int, int, int) {
 
 	var output models.SandboxOutput
 	if err := json.NewDecoder(r).Decode(&output); err != nil {
-		slog.Error("Failed to decode special judge output", "error", err)
+		jc.log.Error("Failed to decode special judge output", "error", err)
 		return constants.OJ_SE, 0, 0
 	}
 
 	exitStatus := output.ExitStatus
 
-	slog.Info("spj result", "status", exitStatus, "program", spjName)
+	jc.log.Info("spj result", "status", exitStatus, "program", spjName)
 
 	if config.SpjProgram == constants.OJ_SPJ_PROGRAM_UPJ {
 		score := float64(exitStatus) / 100.0
-		slog.Info("UPJ score", "score", score)
+		jc.log.Info("UPJ score", "score", score)
 		if exitStatus == 100 {
 			return constants.OJ_AC, 0, 0
 		}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
@@ -8,6 +9,20 @@ import (
 	"strings"
 )
 
+// ReportFDEnv names the environment variable through which the daemon passes
+// the file descriptor that receives the JudgeReport.
+const ReportFDEnv = "HUSTOJ_REPORT_FD"
+
+// InstanceEnv names the environment variable through which the daemon passes
+// its instance name, which selects the judge.conf, work directory and cgroup
+// subtree of the client.
+const InstanceEnv = "HUSTOJ_INSTANCE"
+
+// SiteEnv names the environment variable through which the daemon passes
+// the site a solution belongs to, which selects the [site.<name>] section
+// of judge.conf and with it the database and judge data of the client.
+const SiteEnv = "HUSTOJ_SITE"
+
 func Main() {
 	args := os.Args[1:]
 
@@ -43,10 +58,30 @@ func Main() {
 
 	slog.Info("Starting judge process", "solution_id", solutionID, "runner_id", runnerID)
 
-	if err := client.Run(); err != nil {
+	err = client.Run()
+	writeReport(client)
+	if err != nil {
 		slog.Error("Judge process failed", "error", err)
 		os.Exit(1)
 	}
 
 	slog.Info("Judge process completed successfully")
 }
+
+// writeReport sends the judge report to the daemon if it asked for one.
+func writeReport(client *JudgeClient) {
+	fdStr := os.Getenv(ReportFDEnv)
+	if fdStr == "" {
+		return
+	}
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		slog.Warn("Invalid report fd", "value", fdStr)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "report")
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(client.Report()); err != nil {
+		slog.Warn("Failed to write judge report", "error", err)
+	}
+}
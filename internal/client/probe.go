@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/constants"
+	"github.com/sempr/hustoj-go/pkg/language"
+)
+
+// ProbeLanguage checks that submissions in a language can be judged again:
+// its rootfs mounts and its version command, Cmd.Ver, runs in the sandbox
+// and exits with status 0. A language without a version command is only
+// checked for its rootfs. runnerID names the work directory of the probe
+// and must not be in use by a judgement.
+func ProbeLanguage(ctx context.Context, cfg *config.JudgeConfig, langConfig *language.LangConfig, runnerID string) error {
+	jc := &JudgeClient{
+		config:   cfg,
+		runnerID: runnerID,
+		log:      withSite(slog.Default().With("runner_id", runnerID), cfg),
+		ctx:      ctx,
+	}
+	rootfs, err := jc.setupWorkEnvironment(langConfig)
+	if err != nil {
+		return err
+	}
+	defer jc.cleanupWorkEnvironment(rootfs)
+
+	if langConfig.Cmd.Ver == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(rootfs, "code"), 0755); err != nil {
+		return fmt.Errorf("failed to create code directory: %w", err)
+	}
+	output := jc.runTool(rootfs, langConfig.Cmd.Ver, langConfig.Cmd.Env, "version")
+	if output.SystemError || output.UserStatus == constants.OJ_SE {
+		return fmt.Errorf("sandbox system error: %s", strings.TrimSpace(output.CombinedOutput))
+	}
+	if output.ExitStatus != 0 {
+		return fmt.Errorf("%q exited with status %d: %s", langConfig.Cmd.Ver, output.ExitStatus, strings.TrimSpace(output.CombinedOutput))
+	}
+	return nil
+}
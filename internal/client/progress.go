@@ -0,0 +1,118 @@
+package client
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+	"github.com/sempr/hustoj-go/pkg/repository"
+)
+
+// progressReporter publishes how many tests of a judgement have finished,
+// so the frontend can show a progress bar. Writes are limited to one per
+// interval; an update arriving sooner is written when the interval ends,
+// unless a newer one replaces it first.
+type progressReporter struct {
+	writers  []repository.ProgressWriter
+	closers  []func() error
+	interval time.Duration
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	last    time.Time
+	pending *repository.Progress
+	timer   *time.Timer
+}
+
+// newProgressReporter sets up the progress writers enabled in cfg. Without
+// any, updates are dropped.
+func newProgressReporter(cfg *config.JudgeConfig, db interfaces.Database, log *slog.Logger) *progressReporter {
+	p := &progressReporter{interval: time.Duration(cfg.Progress.Interval) * time.Millisecond, log: log}
+	if cfg.Progress.Table {
+		for {
+			u, ok := db.(interface{ Unwrap() interfaces.Database })
+			if !ok {
+				break
+			}
+			db = u.Unwrap()
+		}
+		if w, ok := db.(repository.ProgressWriter); ok {
+			p.writers = append(p.writers, w)
+		} else {
+			log.Warn("The database backend cannot store progress, ignoring OJ_PROGRESS_TABLE")
+		}
+	}
+	if cfg.Progress.Redis {
+		r := repository.NewRedisProgress(&cfg.Redis, cfg.Progress.Key)
+		p.writers = append(p.writers, r)
+		p.closers = append(p.closers, r.Close)
+	}
+	return p
+}
+
+// update records that done of total tests have finished with verdicts.
+func (p *progressReporter) update(solutionID, done, total int, verdicts []int) {
+	if len(p.writers) == 0 {
+		return
+	}
+	progress := &repository.Progress{SolutionID: solutionID, Done: done, Total: total, Verdicts: slices.Clone(verdicts)}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if wait := p.interval - time.Since(p.last); wait > 0 {
+		if p.pending == nil {
+			p.timer = time.AfterFunc(wait, p.flush)
+		}
+		p.pending = progress
+		return
+	}
+	p.write(progress)
+}
+
+// flush writes the update held back by the rate limit.
+func (p *progressReporter) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending != nil {
+		p.write(p.pending)
+	}
+}
+
+// write must be called with p.mu held.
+func (p *progressReporter) write(progress *repository.Progress) {
+	p.pending = nil
+	p.last = time.Now()
+	for _, w := range p.writers {
+		if err := w.WriteProgress(*progress); err != nil {
+			p.log.Warn("Failed to write progress", "error", err)
+		}
+	}
+}
+
+// finish drops a held back update and removes the progress of the
+// judgement, whose verdict is in the solution table now.
+func (p *progressReporter) finish(solutionID int) {
+	if len(p.writers) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.pending = nil
+	for _, w := range p.writers {
+		if err := w.ClearProgress(solutionID); err != nil {
+			p.log.Warn("Failed to clear progress", "error", err)
+		}
+	}
+}
+
+func (p *progressReporter) close() {
+	for _, c := range p.closers {
+		c()
+	}
+}
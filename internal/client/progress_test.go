@@ -0,0 +1,75 @@
+package client
+
+import (
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/repository"
+)
+
+type recordingProgress struct {
+	mu      sync.Mutex
+	written []repository.Progress
+	cleared []int
+}
+
+func (r *recordingProgress) WriteProgress(p repository.Progress) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.written = append(r.written, p)
+	return nil
+}
+
+func (r *recordingProgress) ClearProgress(solutionID int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cleared = append(r.cleared, solutionID)
+	return nil
+}
+
+func (r *recordingProgress) done() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var done []int
+	for _, p := range r.written {
+		done = append(done, p.Done)
+	}
+	return done
+}
+
+func TestProgressIsRateLimited(t *testing.T) {
+	rec := &recordingProgress{}
+	p := &progressReporter{writers: []repository.ProgressWriter{rec}, interval: 50 * time.Millisecond, log: slog.Default()}
+
+	verdicts := []int{4, 4, 6}
+	for done := 1; done <= 3; done++ {
+		p.update(7, done, 10, verdicts[:done])
+	}
+	if got := rec.done(); len(got) != 1 || got[0] != 1 {
+		t.Fatalf("written = %v; want only the first update before the interval passed", got)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	got := rec.done()
+	if len(got) != 2 || got[1] != 3 {
+		t.Fatalf("written = %v; want the latest update after the interval", got)
+	}
+	if last := rec.written[1]; last.Total != 10 || len(last.Verdicts) != 3 || last.Verdicts[2] != 6 {
+		t.Errorf("last progress = %+v", last)
+	}
+
+	p.mu.Lock()
+	p.last = time.Now()
+	p.mu.Unlock()
+	p.update(7, 4, 10, []int{4, 4, 6, 4})
+	p.finish(7)
+	time.Sleep(100 * time.Millisecond)
+	if got := rec.done(); len(got) != 2 {
+		t.Errorf("written = %v; a held back update must be dropped by finish", got)
+	}
+	if len(rec.cleared) != 1 || rec.cleared[0] != 7 {
+		t.Errorf("cleared = %v; want [7]", rec.cleared)
+	}
+}
@@ -1,16 +1,18 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sempr/hustoj-go/pkg/constants"
+	"github.com/sempr/hustoj-go/pkg/events"
 	"github.com/sempr/hustoj-go/pkg/language"
 	"github.com/sempr/hustoj-go/pkg/models"
 	"github.com/sempr/hustoj-go/pkg/rawtext"
@@ -19,12 +21,28 @@ import (
 )
 
 func (jc *JudgeClient) Run() error {
-	slog.Info("Starting judge process", "runner_id", jc.runnerID)
+	return jc.RunContext(context.Background())
+}
+
+// RunContext judges the submission and kills any running sandbox when
+// runCtx is cancelled. An aborted judgement writes no verdict, so the
+// caller can hand the solution to another judger.
+func (jc *JudgeClient) RunContext(runCtx context.Context) error {
+	jc.ctx = runCtx
+	jc.report = models.JudgeReport{}
+	jc.cpus = jc.runnerCPUs()
+	jc.log.Info("Starting judge process", "runner_id", jc.runnerID, "cpus", jc.cpus)
 
 	ctx, err := jc.prepareJudgeContext()
 	if err != nil {
 		return err
 	}
+	jc.solution = ctx.Solution
+	jc.report.Language = ctx.Solution.Language
+	if !ctx.Solution.InDate.IsZero() {
+		jc.report.QueueWaitMs = time.Since(ctx.Solution.InDate).Milliseconds()
+	}
+	jc.emit(events.Event{Type: events.Accepted, QueueWaitMs: jc.report.QueueWaitMs})
 
 	workDir, cleanupFunc, err := jc.setupEnvironment(ctx)
 	if err != nil {
@@ -75,7 +93,7 @@ func (jc *JudgeClient) prepareJudgeContext() (*JudgeContext, error) {
 		return nil, fmt.Errorf("failed to get language config: %w", err)
 	}
 
-	slog.Info("Retrieved judge information",
+	jc.log.Info("Retrieved judge information",
 		"problem_id", solution.ProblemID,
 		"user_id", solution.UserID,
 		"language", solution.Language,
@@ -96,6 +114,7 @@ func (jc *JudgeClient) prepareJudgeContext() (*JudgeContext, error) {
 func (jc *JudgeClient) setupEnvironment(ctx *JudgeContext) (string, func(), error) {
 	workDir, err := jc.setupWorkEnvironment(ctx.LangConfig)
 	if err != nil {
+		jc.report.SystemErrors++ // The rootfs of the sandbox is broken
 		return "", nil, fmt.Errorf("failed to setup work environment: %w", err)
 	}
 
@@ -121,29 +140,37 @@ func (jc *JudgeClient) setupEnvironment(ctx *JudgeContext) (string, func(), erro
 
 func (jc *JudgeClient) handleCompilation(ctx *JudgeContext, workDir string) error {
 	if err := jc.updateSolutionStatus(constants.OJ_CI); err != nil {
-		slog.Warn("Failed to update to compiling status", "error", err)
+		jc.log.Warn("Failed to update to compiling status", "error", err)
 	}
+	jc.emit(events.Event{Type: events.Compiling})
 
+	compileStart := time.Now()
 	compileResult := jc.compile(ctx.Solution.Language, workDir, ctx.LangConfig)
+	jc.report.CompileMs = time.Since(compileStart).Milliseconds()
+	if err := jc.ctx.Err(); err != nil {
+		return fmt.Errorf("judging aborted during compilation: %w", err)
+	}
 	if compileResult.SystemError {
+		jc.report.SystemErrors++
 		return jc.handleCompilationSystemError(ctx, compileResult)
 	}
 	if compileResult.ExitStatus != 0 {
 		return jc.handleCompilationFailure(ctx, compileResult)
 	}
-	slog.Info("compile ok result", "result", compileResult)
+	jc.log.Info("compile ok result", "result", compileResult)
 	return nil
 }
 
 func (jc *JudgeClient) handleCompilationSystemError(ctx *JudgeContext, compileResult *models.SandboxOutput) error {
-	slog.Error("Compilation system error", "output", compileResult.CombinedOutput)
+	jc.log.Error("Compilation system error", "output", compileResult.CombinedOutput)
 
 	if err := jc.db.AddCompileError(jc.solutionID, compileResult.CombinedOutput); err != nil {
-		slog.Warn("Failed to add compile error info", "error", err)
+		jc.log.Warn("Failed to add compile error info", "error", err)
 	}
 	if err := jc.updateSolutionStatus(constants.OJ_SE); err != nil {
 		return fmt.Errorf("failed to update solution status: %w", err)
 	}
+	jc.emit(events.Event{Type: events.SystemError, Result: constants.OJ_SE, Message: compileResult.CombinedOutput})
 	jc.updateUserStats(ctx.Solution.UserID)
 	jc.updateProblemStats(ctx.Solution.ProblemID, ctx.Solution.ContestID)
 
@@ -151,14 +178,15 @@ func (jc *JudgeClient) handleCompilationSystemError(ctx *JudgeContext, compileRe
 }
 
 func (jc *JudgeClient) handleCompilationFailure(ctx *JudgeContext, compileResult *models.SandboxOutput) error {
-	slog.Info("Compilation failed", "output", compileResult.CombinedOutput)
+	jc.log.Info("Compilation failed", "output", compileResult.CombinedOutput)
 
 	if err := jc.db.AddCompileError(jc.solutionID, compileResult.CombinedOutput); err != nil {
-		slog.Warn("Failed to add compile error info", "error", err)
+		jc.log.Warn("Failed to add compile error info", "error", err)
 	}
 	if err := jc.updateSolutionStatus(constants.OJ_CE); err != nil {
 		return fmt.Errorf("failed to update solution status: %w", err)
 	}
+	jc.emit(events.Event{Type: events.Verdict, Result: constants.OJ_CE, Message: compileResult.CombinedOutput})
 	jc.updateUserStats(ctx.Solution.UserID)
 	jc.updateProblemStats(ctx.Solution.ProblemID, ctx.Solution.ContestID)
 
@@ -167,7 +195,7 @@ func (jc *JudgeClient) handleCompilationFailure(ctx *JudgeContext, compileResult
 
 func (jc *JudgeClient) handleExecution(ctx *JudgeContext, workDir string) error {
 	if err := jc.updateSolutionStatus(constants.OJ_RI); err != nil {
-		slog.Warn("Failed to update to running status", "error", err)
+		jc.log.Warn("Failed to update to running status", "error", err)
 	}
 
 	return jc.runTestCases(ctx.Solution, ctx.Problem, workDir, ctx.LangConfig, ctx.SpjProgram)
@@ -192,17 +220,17 @@ func (jc *JudgeClient) detectSpjType(problem *repository.Problem) int {
 	spjPath := filepath.Join(dataDir, "spj")
 
 	if _, err := os.Stat(upjPath); err == nil {
-		slog.Info("Detected UPJ special judge", "problem_id", problem.ID)
+		jc.log.Info("Detected UPJ special judge", "problem_id", problem.ID)
 		return constants.OJ_SPJ_PROGRAM_UPJ
 	}
 
 	if _, err := os.Stat(tpjPath); err == nil {
-		slog.Info("Detected TPJ special judge", "problem_id", problem.ID)
+		jc.log.Info("Detected TPJ special judge", "problem_id", problem.ID)
 		return constants.OJ_SPJ_PROGRAM_TPJ
 	}
 
 	if _, err := os.Stat(spjPath); err == nil {
-		slog.Info("Detected SPJ special judge", "problem_id", problem.ID)
+		jc.log.Info("Detected SPJ special judge", "problem_id", problem.ID)
 		return constants.OJ_SPJ_PROGRAM_SPJ
 	}
 
@@ -211,12 +239,12 @@ func (jc *JudgeClient) detectSpjType(problem *repository.Problem) int {
 
 func (jc *JudgeClient) findDataFiles(problemID int) ([][]string, error) {
 	dataDir := filepath.Join(jc.config.OJHome, "data", strconv.Itoa(problemID))
-	slog.Info("Scanning data files", "directory", dataDir)
+	jc.log.Info("Scanning data files", "directory", dataDir)
 
 	entries, err := os.ReadDir(dataDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			slog.Warn("Data directory not found", "directory", dataDir)
+			jc.log.Warn("Data directory not found", "directory", dataDir)
 			return [][]string{}, nil
 		}
 		return nil, fmt.Errorf("failed to read data directory %s: %w", dataDir, err)
@@ -235,7 +263,7 @@ func (jc *JudgeClient) findDataFiles(problemID int) ([][]string, error) {
 	}
 
 	sort.Strings(inFiles)
-	slog.Info("Found .in files", "count", len(inFiles))
+	jc.log.Info("Found .in files", "count", len(inFiles))
 
 	var result [][]string
 	for _, inFileName := range inFiles {
@@ -248,13 +276,13 @@ func (jc *JudgeClient) findDataFiles(problemID int) ([][]string, error) {
 		if _, err := os.Stat(outFullPath); err == nil {
 			outPath = outFullPath
 		} else if !os.IsNotExist(err) {
-			slog.Warn("Cannot access .out file", "path", outFullPath, "error", err)
+			jc.log.Warn("Cannot access .out file", "path", outFullPath, "error", err)
 		}
 
 		result = append(result, []string{inFullPath, outPath})
 	}
 
-	slog.Info("Data file pairing completed", "pairs", len(result))
+	jc.log.Info("Data file pairing completed", "pairs", len(result))
 	return result, nil
 }
 
@@ -275,7 +303,7 @@ func (jc *JudgeClient) writeSourceCode(source string, langID int, workDir string
 		return fmt.Errorf("failed to write source code: %w", err)
 	}
 
-	slog.Info("Source code written", "path", filePath)
+	jc.log.Info("Source code written", "path", filePath)
 	return nil
 }
 
@@ -293,10 +321,11 @@ func (jc *JudgeClient) handleRawTextJudge(solution *repository.Solution, problem
 	)
 
 	if err != nil {
-		slog.Error("Rawtext judge error", "error", err)
+		jc.log.Error("Rawtext judge error", "error", err)
 		if err := jc.updateSolutionStatus(constants.OJ_RE); err != nil {
 			return fmt.Errorf("failed to update solution status: %w", err)
 		}
+		jc.emit(events.Event{Type: events.Verdict, Result: constants.OJ_RE, Message: err.Error()})
 		return nil
 	}
 
@@ -307,12 +336,13 @@ func (jc *JudgeClient) handleRawTextJudge(solution *repository.Solution, problem
 		rate = float64(userScore) / float64(totalScore)
 	}
 
-	if err := jc.db.UpdateSolution(jc.solutionID, result, 0, 0, rate); err != nil {
+	if err := jc.updateSolution(result, 0, 0, rate); err != nil {
 		return fmt.Errorf("failed to update solution: %w", err)
 	}
+	jc.emit(events.Event{Type: events.Verdict, Result: result, PassRate: rate})
 
 	if err := jc.db.AddRuntimeInfo(jc.solutionID, details); err != nil {
-		slog.Warn("Failed to add runtime info", "error", err)
+		jc.log.Warn("Failed to add runtime info", "error", err)
 	}
 
 	return nil
@@ -326,6 +356,10 @@ func (jc *JudgeClient) runTestCases(solution *repository.Solution, problem *repo
 		return err
 	}
 
+	jc.progress = newProgressReporter(jc.config, jc.db, jc.log)
+	defer jc.progress.close()
+	defer jc.progress.finish(jc.solutionID)
+
 	testResults, totalResults, stats, err := jc.executeAllTestCases(ctx)
 	if err != nil {
 		return err
@@ -390,13 +424,18 @@ func (jc *JudgeClient) executeAllTestCases(ctx *TestContext) ([]subtask.TestResu
 		testResults  []subtask.TestResult
 		totalResults models.TotalResults
 		stats        ExecutionStats
+		verdicts     []int
 	)
 
-	for _, dataFile := range ctx.DataFiles {
+	for i, dataFile := range ctx.DataFiles {
+		jc.emit(events.Event{Type: events.Running, Test: i + 1, Tests: len(ctx.DataFiles)})
 		testResult, oneResult, err := jc.executeSingleTestCase(ctx, dataFile)
 		if err != nil {
 			return nil, models.TotalResults{}, ExecutionStats{}, err
 		}
+		if err := jc.ctx.Err(); err != nil {
+			return nil, models.TotalResults{}, ExecutionStats{}, fmt.Errorf("judging aborted: %w", err)
+		}
 
 		// Update statistics
 		if testResult.Time > stats.TotalTime {
@@ -408,6 +447,8 @@ func (jc *JudgeClient) executeAllTestCases(ctx *TestContext) ([]subtask.TestResu
 
 		testResults = append(testResults, testResult)
 		totalResults.Results = append(totalResults.Results, oneResult)
+		verdicts = append(verdicts, testResult.Result)
+		jc.progress.update(jc.solutionID, len(testResults), len(ctx.DataFiles), verdicts)
 	}
 
 	return testResults, totalResults, stats, nil
@@ -456,9 +497,9 @@ func (jc *JudgeClient) calculateSpjMark(result int, ctx *TestContext) float64 {
 
 func (jc *JudgeClient) logTestResult(filename string, result int) {
 	if result != constants.OJ_AC {
-		slog.Warn("Test case failed", "data_file", filename, "result", result)
+		jc.log.Warn("Test case failed", "data_file", filename, "result", result)
 	} else {
-		slog.Info("Test case passed", "data_file", filename)
+		jc.log.Info("Test case passed", "data_file", filename)
 	}
 }
 
@@ -469,19 +510,25 @@ func (jc *JudgeClient) processTestResults(solution *repository.Solution, testRes
 	passRate := subtaskScore.PassRate
 
 	if err := jc.addRuntimeInfo(jc.solutionID, totalResults); err != nil {
-		slog.Warn("Failed to add runtime info", "error", err)
+		jc.log.Warn("Failed to add runtime info", "error", err)
 	}
 
-	slog.Info("Judge completed",
+	jc.log.Info("Judge completed",
 		"final_result", totalResults.FinalResult,
 		"total_time_ms", stats.TotalTime,
 		"peak_memory_kb", stats.PeakMemory,
 		"pass_rate", passRate,
 	)
 
-	if err := jc.db.UpdateSolution(jc.solutionID, totalResults.FinalResult, stats.TotalTime, stats.PeakMemory, passRate); err != nil {
+	if err := jc.updateSolution(totalResults.FinalResult, stats.TotalTime, stats.PeakMemory, passRate); err != nil {
 		return fmt.Errorf("failed to update final solution result: %w", err)
 	}
+	verdict := events.Event{Type: events.Verdict, Result: totalResults.FinalResult,
+		TimeMs: stats.TotalTime, MemoryKB: stats.PeakMemory, PassRate: passRate}
+	if verdict.Result == constants.OJ_SE {
+		verdict.Type = events.SystemError
+	}
+	jc.emit(verdict)
 
 	jc.updateUserStats(solution.UserID)
 	jc.updateProblemStats(solution.ProblemID, solution.ContestID)
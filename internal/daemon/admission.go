@@ -0,0 +1,210 @@
+package daemon
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// defaultMemoryOverhead is the MB a judgement needs beyond the memory limit
+// of its problem when the language does not say: the client, the compiler
+// and the sandbox itself.
+const defaultMemoryOverhead = 64
+
+// Where host resources are read from, replaced in tests.
+var (
+	procDir   = "/proc"
+	cgroupDir = "/sys/fs/cgroup"
+)
+
+// memoryBudget is the memory left for judgements about to start, in MB.
+type memoryBudget struct {
+	unreserved int // Capacity minus the reservations of running judgements
+	available  int // Free memory right now, minus OJ_MEMORY_RESERVE
+}
+
+// admit reports whether a judgement needing need MB fits, and if so
+// reserves it. An idle judger takes any job, so a problem whose limit
+// exceeds the host still gets judged.
+func (b *memoryBudget) admit(need int, idle bool) bool {
+	if !idle && (need > b.unreserved || need > b.available) {
+		return false
+	}
+	b.unreserved -= need
+	b.available -= need
+	return true
+}
+
+// memoryBudget returns what is left for new judgements, or nil if memory
+// admission is disabled. A host whose memory cannot be read disables it
+// until the next reload.
+func (w *Worker) memoryBudget() *memoryBudget {
+	if !w.cfg.MemoryAdmission {
+		return nil
+	}
+	total, available, err := hostMemory()
+	if err != nil {
+		slog.Warn("Could not read host memory, disabling OJ_MEMORY_ADMISSION", "err", err)
+		w.cfg.MemoryAdmission = false
+		return nil
+	}
+	reserved := 0
+	if w.pool != nil {
+		reserved = w.pool.memory()
+	} else {
+		for _, job := range w.running {
+			reserved += job.memory
+		}
+	}
+	return &memoryBudget{
+		unreserved: total - w.cfg.MemoryReserve - reserved,
+		available:  available - w.cfg.MemoryReserve,
+	}
+}
+
+// jobMemory returns the MB a judgement of pending may use: the memory limit
+// of its problem plus the overhead of its language. Jobs the fetcher could
+// not describe are looked up in the database once, and the limit is kept
+// on the queued job.
+func (w *Worker) jobMemory(pending *Job) int {
+	overhead := w.langSched(pending.Language).MemoryOverhead
+	if overhead <= 0 {
+		overhead = defaultMemoryOverhead
+	}
+	if pending.MemoryLimit <= 0 && w.shared != nil {
+		if solution, err := w.shared.db.GetSolution(pending.SolutionID); err != nil {
+			slog.Warn("Could not look up solution for memory admission", "solution_id", pending.SolutionID, "err", err)
+		} else if problem, err := w.shared.db.GetProblem(solution.ProblemID); err != nil {
+			slog.Warn("Could not look up problem for memory admission", "problem_id", solution.ProblemID, "err", err)
+		} else {
+			pending.MemoryLimit = problem.MemLimit
+			w.queue.setMemoryLimit(pending.SolutionID, problem.MemLimit)
+		}
+	}
+	return pending.MemoryLimit + overhead
+}
+
+// loadTooHigh reports whether the 1-minute load average exceeds
+// OJ_LOAD_LIMIT per CPU, in which case no job is started. Crossing the
+// limit is logged once in each direction.
+func (w *Worker) loadTooHigh() bool {
+	if w.cfg.LoadLimit <= 0 {
+		return false
+	}
+	load, err := loadAverage()
+	if err != nil {
+		slog.Warn("Could not read the load average, disabling OJ_LOAD_LIMIT", "err", err)
+		w.cfg.LoadLimit = 0
+		return false
+	}
+	limit := w.cfg.LoadLimit * float64(runtime.NumCPU())
+	high := load > limit
+	if high != w.overloaded {
+		if high {
+			slog.Warn("Load average too high, holding back new jobs", "load", load, "limit", limit)
+		} else {
+			slog.Info("Load average back to normal, taking new jobs", "load", load, "limit", limit)
+		}
+		w.overloaded = high
+	}
+	return high
+}
+
+// hostMemory returns the memory of the host, or of the cgroup of the daemon
+// if that is smaller, and how much of it is free, in MB.
+func hostMemory() (total, available int, err error) {
+	info, err := readMeminfo(filepath.Join(procDir, "meminfo"))
+	if err != nil {
+		return 0, 0, err
+	}
+	total, available = info["MemTotal"]/1024, info["MemAvailable"]/1024
+	if limit, current, ok := cgroupMemory(); ok {
+		total = min(total, limit)
+		available = min(available, limit-current)
+	}
+	return total, available, nil
+}
+
+// readMeminfo parses the kB values of /proc/meminfo.
+func readMeminfo(path string) (map[string]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			continue
+		}
+		if n, err := strconv.Atoi(fields[0]); err == nil {
+			info[key] = n
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if _, ok := info["MemAvailable"]; !ok {
+		return nil, fmt.Errorf("no MemAvailable in %s", path)
+	}
+	return info, nil
+}
+
+// cgroupMemory returns the tightest memory.max on the cgroup v2 path of the
+// daemon and the usage of that cgroup, in MB. ok is false without a limit.
+func cgroupMemory() (limit, current int, ok bool) {
+	data, err := os.ReadFile(filepath.Join(procDir, "self", "cgroup"))
+	if err != nil {
+		return 0, 0, false
+	}
+	path, found := strings.CutPrefix(strings.TrimSpace(string(data)), "0::")
+	if !found {
+		return 0, 0, false // cgroup v1
+	}
+	for dir := filepath.Clean("/" + path); ; dir = filepath.Dir(dir) {
+		maxBytes, err := readCgroupInt(filepath.Join(cgroupDir, dir, "memory.max"))
+		if err == nil && (!ok || maxBytes < limit) {
+			if cur, err := readCgroupInt(filepath.Join(cgroupDir, dir, "memory.current")); err == nil {
+				limit, current, ok = maxBytes, cur, true
+			}
+		}
+		if dir == "/" {
+			break
+		}
+	}
+	return limit >> 20, current >> 20, ok
+}
+
+// readCgroupInt reads a cgroup file holding a byte count. "max" is an error.
+func readCgroupInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// loadAverage returns the 1-minute load average.
+func loadAverage() (float64, error) {
+	data, err := os.ReadFile(filepath.Join(procDir, "loadavg"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty %s", filepath.Join(procDir, "loadavg"))
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
@@ -0,0 +1,120 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+)
+
+// fakeHost points procDir and cgroupDir at a temporary tree with the given
+// files, relative to its root.
+func fakeHost(t *testing.T, files map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldProc, oldCgroup := procDir, cgroupDir
+	procDir, cgroupDir = filepath.Join(root, "proc"), filepath.Join(root, "cgroup")
+	t.Cleanup(func() { procDir, cgroupDir = oldProc, oldCgroup })
+}
+
+func TestHostMemoryUsesTightestCgroup(t *testing.T) {
+	fakeHost(t, map[string]string{
+		"proc/meminfo":                                     "MemTotal:        8388608 kB\nMemFree:  100 kB\nMemAvailable:    6291456 kB\n",
+		"proc/self/cgroup":                                 "0::/judge.slice/judged.service\n",
+		"cgroup/judge.slice/memory.max":                    strconv.Itoa(4 << 30),
+		"cgroup/judge.slice/memory.current":                strconv.Itoa(1 << 30),
+		"cgroup/judge.slice/judged.service/memory.max":     "max\n",
+		"cgroup/judge.slice/judged.service/memory.current": "1024\n",
+	})
+
+	total, available, err := hostMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4096 || available != 3072 {
+		t.Errorf("hostMemory = %d, %d MB; want 4096, 3072 from the cgroup limit", total, available)
+	}
+}
+
+func TestMemoryBudgetAdmitsByReservation(t *testing.T) {
+	fakeHost(t, map[string]string{
+		"proc/meminfo": "MemTotal: 4194304 kB\nMemAvailable: 3145728 kB\n",
+	})
+	w := NewWorker(&DaemonConfig{JudgeConfig: &config.JudgeConfig{}, MaxRunning: 4, MemoryAdmission: true, MemoryReserve: 512}, &stubFetcher{})
+
+	need := w.jobMemory(&Job{SolutionID: 1, Language: -1, MemoryLimit: 1024})
+	if need != 1024+defaultMemoryOverhead {
+		t.Fatalf("jobMemory = %d; want the limit plus %d MB overhead", need, defaultMemoryOverhead)
+	}
+
+	// 4096 MB - 512 MB reserve - 1088 MB running leaves 2496 MB.
+	w.running[0] = &runningJob{solutionID: 9, memory: need}
+	b := w.memoryBudget()
+	if b == nil || b.unreserved != 2496 || b.available != 2560 {
+		t.Fatalf("budget = %+v; want 2496 unreserved, 2560 available", b)
+	}
+	big := 2048 + defaultMemoryOverhead
+	if !b.admit(big, false) {
+		t.Error("first job did not fit")
+	}
+	if b.admit(big, false) {
+		t.Error("second job was admitted beyond the budget")
+	}
+	if !b.admit(8192, true) {
+		t.Error("an idle judger must take a job whatever its memory limit")
+	}
+}
+
+func TestJobMemoryLooksUpOnce(t *testing.T) {
+	w := NewWorker(&DaemonConfig{JudgeConfig: &config.JudgeConfig{}, MaxRunning: 1}, &stubFetcher{})
+	db := newStubDB(interfaces.Problem{ID: 1000, MemLimit: 256})
+	w.shared = &sharedClient{db: db}
+	never := func(int) bool { return false }
+
+	w.queue.replace(plainJobs([]int{5}), never)
+	for pass := 0; pass < 3; pass++ {
+		pending := w.queue.jobs[0]
+		if need := w.jobMemory(&pending); need != 256+defaultMemoryOverhead {
+			t.Fatalf("jobMemory = %d; want the limit of the problem plus overhead", need)
+		}
+		w.queue.replace(plainJobs([]int{5}), never) // Refetched by the next pass
+	}
+	if db.lookups != 1 {
+		t.Errorf("solution looked up %d times; want once", db.lookups)
+	}
+}
+
+func TestMemoryAdmissionDisabledWithoutMeminfo(t *testing.T) {
+	fakeHost(t, nil)
+	w := NewWorker(&DaemonConfig{JudgeConfig: &config.JudgeConfig{}, MaxRunning: 1, MemoryAdmission: true}, &stubFetcher{})
+	if b := w.memoryBudget(); b != nil || w.cfg.MemoryAdmission {
+		t.Errorf("budget = %+v, MemoryAdmission = %v; want admission disabled", b, w.cfg.MemoryAdmission)
+	}
+}
+
+func TestLoadTooHigh(t *testing.T) {
+	cpus := float64(runtime.NumCPU())
+	w := NewWorker(&DaemonConfig{JudgeConfig: &config.JudgeConfig{}, MaxRunning: 1, LoadLimit: 1.5}, &stubFetcher{})
+
+	fakeHost(t, map[string]string{"proc/loadavg": strconv.FormatFloat(2*cpus, 'f', 2, 64) + " 1.00 1.00 2/300 1234\n"})
+	if !w.loadTooHigh() {
+		t.Error("load of 2 per CPU not too high for a limit of 1.5")
+	}
+	fakeHost(t, map[string]string{"proc/loadavg": strconv.FormatFloat(cpus, 'f', 2, 64) + " 1.00 1.00 2/300 1234\n"})
+	if w.loadTooHigh() {
+		t.Error("load of 1 per CPU too high for a limit of 1.5")
+	}
+}
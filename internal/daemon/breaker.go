@@ -0,0 +1,228 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sempr/hustoj-go/internal/client"
+	"github.com/sempr/hustoj-go/pkg/backoff"
+	"github.com/sempr/hustoj-go/pkg/events"
+	"github.com/sempr/hustoj-go/pkg/language"
+	"github.com/sempr/hustoj-go/pkg/models"
+)
+
+// A disabled language is probed after delays growing within these bounds.
+const (
+	probeBackoffBase = 30 * time.Second
+	probeBackoffMax  = 10 * time.Minute
+)
+
+// probeLanguage checks whether langID can be judged again, see
+// client.ProbeLanguage. Replaced in tests.
+var probeLanguage = func(ctx context.Context, cfg *DaemonConfig, langs *language.Manager, langID int) error {
+	if langs == nil {
+		return errors.New("no language configs")
+	}
+	// Looked up on every probe, so a fixed config is picked up on reload.
+	lc, err := langs.GetLanguageConfig(langID)
+	if err != nil {
+		return err
+	}
+	return client.ProbeLanguage(ctx, cfg.JudgeConfig, lc, fmt.Sprintf("probe%d", langID))
+}
+
+// langBreaker stops fetching a language once OJ_LANG_BREAKER judgements of
+// it in a row ended in sandbox system errors, typically because its rootfs
+// is gone. Every job of such a language would only burn a slot and end as
+// OJ_SE. The language is probed in the background and fetched again once a
+// probe succeeds.
+type langBreaker struct {
+	failures map[int]int          // Consecutive judgements with sandbox system errors by language
+	disabled map[int]disabledLang // Languages not fetched
+	probes   chan int             // Languages whose probe succeeded
+	backoff  backoff.Backoff      // Delays between probes, copied by each probe
+	ctx      context.Context      // Cancelled when the worker loop returns
+}
+
+type disabledLang struct {
+	since     time.Time
+	stopProbe context.CancelFunc
+}
+
+func newLangBreaker() langBreaker {
+	return langBreaker{
+		failures: make(map[int]int),
+		disabled: make(map[int]disabledLang),
+		probes:   make(chan int),
+		backoff:  backoff.Backoff{Base: probeBackoffBase, Max: probeBackoffMax},
+		ctx:      context.Background(),
+	}
+}
+
+// isDisabled reports whether jobs of langID are held back.
+func (b *langBreaker) isDisabled(langID int) bool {
+	_, ok := b.disabled[langID]
+	return ok
+}
+
+// disabledLanguages returns the held back languages in ascending order.
+func (b *langBreaker) disabledLanguages() []int {
+	langs := make([]int, 0, len(b.disabled))
+	for langID := range b.disabled {
+		langs = append(langs, langID)
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+// observeLanguage counts a finished judgement towards the breaker of its
+// language. A report with sandbox system errors is a failure and a final
+// verdict without any a success; judgements the client could not finish,
+// killed or timed out say nothing about the language.
+func (w *Worker) observeLanguage(job *runningJob, report *models.JudgeReport) {
+	if w.cfg.LangBreaker <= 0 || report == nil {
+		return
+	}
+	langID := job.language
+	if langID < 0 {
+		langID = report.Language
+	}
+	b := &w.breaker
+	if report.SystemErrors == 0 {
+		if report.Result >= OJ_AC && report.Result != OJ_SE {
+			delete(b.failures, langID)
+		}
+		return
+	}
+	if b.isDisabled(langID) {
+		return // Fetched before the language was disabled
+	}
+	b.failures[langID]++
+	if b.failures[langID] >= w.cfg.LangBreaker {
+		w.disableLanguage(langID)
+	}
+}
+
+// disableLanguage stops fetching langID, raises the alarm and starts
+// probing the language.
+func (w *Worker) disableLanguage(langID int) {
+	b := &w.breaker
+	failures := b.failures[langID]
+	delete(b.failures, langID)
+	message := fmt.Sprintf("Language %d disabled on %s after %d judgements in a row ended in sandbox system errors. "+
+		"It is enabled again once its rootfs mounts and its version command runs.",
+		langID, w.cfg.Judger, failures)
+	slog.Error("Language disabled after repeated sandbox system errors", "language", langID, "failures", failures)
+	w.publishLanguage(events.LanguageDisabled, langID, message)
+
+	cfg := *w.cfg // The worker changes its own copy on reload
+	var langs *language.Manager
+	if w.shared != nil {
+		langs = w.shared.langs
+	}
+	ctx, stop := context.WithCancel(b.ctx)
+	b.disabled[langID] = disabledLang{since: time.Now(), stopProbe: stop}
+	w.reconfigureFetcher()
+	go probeUntilUp(ctx, b.backoff, b.probes, &cfg, langs, langID)
+}
+
+// enableLanguage fetches langID again after a successful probe.
+func (w *Worker) enableLanguage(langID int) {
+	d, ok := w.breaker.disabled[langID]
+	if !ok {
+		return // Enabled in the meantime by a reload
+	}
+	d.stopProbe()
+	delete(w.breaker.disabled, langID)
+	outage := time.Since(d.since).Round(time.Second)
+	slog.Info("Language probe succeeded, language enabled again", "language", langID, "outage", outage)
+	w.publishLanguage(events.LanguageEnabled, langID,
+		fmt.Sprintf("Language %d enabled again on %s after %s.", langID, w.cfg.Judger, outage))
+	w.reconfigureFetcher()
+	w.queue.stale = true
+}
+
+// enableAllLanguages closes every breaker, when OJ_LANG_BREAKER is set to 0.
+func (w *Worker) enableAllLanguages() {
+	for _, langID := range w.breaker.disabledLanguages() {
+		w.breaker.disabled[langID].stopProbe()
+		delete(w.breaker.disabled, langID)
+		slog.Info("Breakers disabled, language enabled again", "language", langID)
+	}
+	clear(w.breaker.failures)
+	w.reconfigureFetcher()
+	w.queue.stale = true
+}
+
+// probeUntilUp checks langID until it can be judged again, then reports it
+// on up. It gives up when ctx is cancelled.
+func probeUntilUp(ctx context.Context, delays backoff.Backoff, up chan<- int, cfg *DaemonConfig, langs *language.Manager, langID int) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delays.Next()):
+		}
+		err := probeLanguage(ctx, cfg, langs, langID)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Language probe failed", "language", langID, "err", err, "attempt", delays.Attempts())
+	}
+	select {
+	case up <- langID:
+	case <-ctx.Done():
+	}
+}
+
+// fetchConfig returns the configuration the fetcher works with: that of the
+// worker without the disabled languages.
+func (w *Worker) fetchConfig() *DaemonConfig {
+	if len(w.breaker.disabled) == 0 {
+		return w.cfg
+	}
+	var ids []string
+	for _, id := range strings.Split(w.cfg.LangSet, ",") {
+		langID, err := strconv.Atoi(strings.TrimSpace(id))
+		if err == nil && w.breaker.isDisabled(langID) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		ids = []string{"-1"} // No language id, so nothing is fetched
+	}
+	cfg := *w.cfg
+	cfg.LangSet = strings.Join(ids, ",")
+	return &cfg
+}
+
+// reconfigureFetcher hands the languages and slots to fetchers that filter
+// by them. Jobs of disabled languages fetched anyway are skipped by work.
+func (w *Worker) reconfigureFetcher() {
+	if r, ok := w.fetcher.(JobReconfigurer); ok {
+		r.Reconfigure(w.fetchConfig())
+	}
+}
+
+// publishLanguage sends an event about a language to the configured sinks.
+func (w *Worker) publishLanguage(typ events.Type, langID int, message string) {
+	if w.shared == nil {
+		return
+	}
+	w.shared.publish(events.Event{
+		Type:     typ,
+		Language: langID,
+		Judger:   w.cfg.Judger,
+		Message:  message,
+	})
+}
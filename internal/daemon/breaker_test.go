@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/backoff"
+	"github.com/sempr/hustoj-go/pkg/language"
+	"github.com/sempr/hustoj-go/pkg/models"
+)
+
+// finishWith ends a fake judgement of langID with report.
+func finishWith(w *Worker, solutionID, langID int, report *models.JudgeReport) {
+	w.running[0] = &runningJob{solutionID: solutionID, language: langID, cancel: func() {}}
+	w.finish(jobResult{clientID: 0, report: report})
+}
+
+func TestLanguageBreaker(t *testing.T) {
+	var probes atomic.Int32
+	realProbe := probeLanguage
+	t.Cleanup(func() { probeLanguage = realProbe })
+	probeLanguage = func(ctx context.Context, cfg *DaemonConfig, langs *language.Manager, langID int) error {
+		if probes.Add(1) == 1 {
+			return context.DeadlineExceeded // The first probe still fails
+		}
+		return nil
+	}
+
+	f := newTestSQLiteFetcher(t, "j1")
+	cfg := reloadTestConfig()
+	cfg.LangBreaker = 3
+	w := NewWorker(cfg, f)
+	w.breaker.backoff = backoff.Backoff{Base: time.Millisecond, Max: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.breaker.ctx = ctx
+
+	systemError := &models.JudgeReport{Language: 1, Result: OJ_SE, SystemErrors: 1}
+	finishWith(w, 10, 1, systemError)
+	finishWith(w, 11, 1, systemError)
+	finishWith(w, 12, 1, &models.JudgeReport{Language: 1, Result: OJ_AC})
+	finishWith(w, 13, 1, systemError)
+	finishWith(w, 14, 1, systemError)
+	finishWith(w, 15, 0, systemError) // Another language
+	finishWith(w, 16, 1, nil)         // Crashed client, says nothing
+	if w.breaker.isDisabled(1) {
+		t.Fatal("language disabled before 3 system errors in a row")
+	}
+	finishWith(w, 17, 1, systemError)
+	if !slices.Equal(w.breaker.disabledLanguages(), []int{1}) {
+		t.Fatalf("disabled = %v; want [1]", w.breaker.disabledLanguages())
+	}
+	if jobs, _ := f.GetJobs(10); slices.Contains(jobs, 2) {
+		t.Errorf("GetJobs = %v; the solution of the disabled language was fetched", jobs)
+	}
+	if out := w.ctlStatus(); !slices.Equal(out.Disabled, []int{1}) {
+		t.Errorf("status lists disabled languages %v; want [1]", out.Disabled)
+	}
+
+	select {
+	case langID := <-w.breaker.probes:
+		w.enableLanguage(langID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no successful probe")
+	}
+	if w.breaker.isDisabled(1) || probes.Load() != 2 {
+		t.Errorf("disabled = %v after %d probes; want enabled after 2", w.breaker.disabledLanguages(), probes.Load())
+	}
+	if jobs, _ := f.GetJobs(10); !slices.Contains(jobs, 2) {
+		t.Errorf("GetJobs = %v; the solution of the enabled language was not fetched", jobs)
+	}
+}
+
+func TestLanguageBreakerReloadedOff(t *testing.T) {
+	f := newTestSQLiteFetcher(t, "j1")
+	cfg := reloadTestConfig()
+	cfg.LangSet = "1"
+	cfg.LangBreaker = 1
+	w := NewWorker(cfg, f)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w.breaker.ctx = ctx
+
+	finishWith(w, 10, 1, &models.JudgeReport{Language: 1, Result: OJ_SE, SystemErrors: 1})
+	if jobs, err := f.GetJobs(10); err != nil || len(jobs) != 0 {
+		t.Errorf("GetJobs = %v, %v; want nothing with every language disabled", jobs, err)
+	}
+
+	next := reloadTestConfig()
+	next.LangSet = "1"
+	w.reload(next)
+	if w.breaker.isDisabled(1) {
+		t.Error("language still disabled after OJ_LANG_BREAKER=0")
+	}
+	if jobs, _ := f.GetJobs(10); !slices.Equal(jobs, []int{2}) {
+		t.Errorf("GetJobs = %v; want [2]", jobs)
+	}
+}
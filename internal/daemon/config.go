@@ -1,12 +1,9 @@
 package daemon
 
 import (
-	"bufio"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
 
 	"github.com/sempr/hustoj-go/pkg/config"
 )
@@ -14,38 +11,50 @@ import (
 // DaemonConfig stores all configuration for judged daemon
 type DaemonConfig struct {
 	*config.JudgeConfig
-	MaxRunning     int
-	SleepTime      int
-	TotalJudges    int
-	JudgeMod       int
-	LangSet        string
-	HTTPJudge      bool
-	HTTPBaseURL    string
-	HTTPAPIPath    string
-	HTTPLoginPath  string
-	HTTPUsername   string
-	HTTPPassword   string
-	RedisEnable    bool
-	RedisServer    string
-	RedisPort      int
-	RedisAuth      string
-	RedisQName     string
-	UDPEnable      bool
-	UDPServer      string
-	UDPPort        int
-	UseDocker      bool
-	DockerPath     string
-	InternalClient bool
-	TurboMode      int
+	MaxRunning      int
+	SleepTime       int
+	TotalJudges     int
+	JudgeMod        int
+	LangSet         string
+	RedisEnable     bool
+	RedisServer     string
+	RedisPort       int
+	RedisAuth       string
+	RedisQName      string
+	RedisRequeue    int // Seconds without renewal before an unacknowledged job is requeued
+	UDPEnable       bool
+	UDPServer       string
+	UDPPort         int
+	UseDocker       bool
+	DockerPath      string
+	InternalClient  bool
+	TurboMode       int
+	DrainTimeout    int     // Seconds to wait for running judgements on shutdown
+	RecoverAge      int     // Also recover other judgers' jobs stuck this many seconds
+	MetricsAddr     string  // Listen address of the Prometheus endpoint, empty to disable
+	JudgeTimeout    int     // Upper bound in seconds on the wall time of one judgement, 0 to disable
+	ClientRetries   int     // Times a solution is requeued after its client crashed
+	StarveLimit     int     // Jobs started ahead of waiting lower-priority jobs before one goes first, 0 to disable
+	FairShare       int     // Interleave pending jobs by user (1) or by contest and user (2), 0 to disable
+	UserSlots       int     // Slots one user may hold at a time, 0 for no limit
+	LeaseTime       int     // Seconds a MySQL claim lasts without renewal, 0 to shard by OJ_MOD
+	CtlSocket       string  // Unix socket of `hustoj-go ctl`, empty to disable
+	MemoryAdmission bool    // Start jobs only while their memory limits fit in the host memory
+	MemoryReserve   int     // MB of host memory memory admission leaves to the system
+	LoadLimit       float64 // Load average per CPU above which no job is started, 0 to disable
+	SiteWeight      int     // Share of the slots of a site relative to the other sites
+	ConfHome        string  // OJ_HOME holding etc/, which a site may move OJHome away from
+	LangBreaker     int     // Judgements in a row with sandbox system errors that disable a language, 0 to disable
 }
 
-// LoadDaemonConfig reads judge.conf file and returns a DaemonConfig struct
-func LoadDaemonConfig(path string) (*DaemonConfig, error) {
-	// Determine home directory from config file path
-	homePath := filepath.Dir(filepath.Dir(path))
+// LoadDaemonConfig reads the judge.conf of instance in homePath and returns
+// a DaemonConfig struct. A non-empty site selects a [site.<site>] section,
+// whose settings override those at the top of the file.
+func LoadDaemonConfig(homePath, instance, site string) (*DaemonConfig, error) {
+	path := config.ConfPath(homePath, instance)
 
 	// Load base judge configuration
-	baseConfig, err := config.LoadJudgeConf(homePath)
+	baseConfig, err := config.LoadSiteConf(homePath, instance, site)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load base config: %w", err)
 	}
@@ -53,6 +62,7 @@ func LoadDaemonConfig(path string) (*DaemonConfig, error) {
 	// Default daemon-specific values
 	cfg := &DaemonConfig{
 		JudgeConfig:    baseConfig,
+		ConfHome:       homePath,
 		MaxRunning:     3,
 		SleepTime:      1,
 		TotalJudges:    1,
@@ -60,34 +70,34 @@ func LoadDaemonConfig(path string) (*DaemonConfig, error) {
 		LangSet:        "0,1,3,6",
 		DockerPath:     "/usr/bin/docker",
 		UDPServer:      "127.0.0.1",
+		RedisRequeue:   900,
+		JudgeTimeout:   1800,
+		ClientRetries:  2,
+		DrainTimeout:   60,
+		StarveLimit:    10,
 		UDPPort:        1536,
+		CtlSocket:      CtlSocketPath(homePath, baseConfig.Instance),
+		MemoryReserve:  512,
+		SiteWeight:     1,
+		LangBreaker:    5,
 		InternalClient: true,
 	}
 
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("could not open %s: %w", path, err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-
+	if err := config.ScanConf(path, site, func(key, value string) {
 		assignDaemonConfigValue(cfg, key, value)
+	}); err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
 	}
+	return cfg, nil
+}
 
-	return cfg, scanner.Err()
+// confHome returns the OJ_HOME whose etc/ holds judge.conf and the language
+// configs. The judge data of a site may live elsewhere, in OJHome.
+func (cfg *DaemonConfig) confHome() string {
+	if cfg.ConfHome != "" {
+		return cfg.ConfHome
+	}
+	return cfg.OJHome
 }
 
 func assignDaemonConfigValue(cfg *DaemonConfig, key, value string) {
@@ -102,18 +112,6 @@ func assignDaemonConfigValue(cfg *DaemonConfig, key, value string) {
 		cfg.JudgeMod, _ = strconv.Atoi(value)
 	case "OJ_LANG_SET":
 		cfg.LangSet = value
-	case "OJ_HTTP_JUDGE":
-		cfg.HTTPJudge, _ = strconv.ParseBool(value)
-	case "OJ_HTTP_BASEURL":
-		cfg.HTTPBaseURL = value
-	case "OJ_HTTP_API_PATH":
-		cfg.HTTPAPIPath = value
-	case "OJ_HTTP_LOGIN_PATH":
-		cfg.HTTPLoginPath = value
-	case "OJ_HTTP_USERNAME":
-		cfg.HTTPUsername = value
-	case "OJ_HTTP_PASSWORD":
-		cfg.HTTPPassword = value
 	case "OJ_REDISENABLE":
 		v, _ := strconv.Atoi(value)
 		cfg.RedisEnable = (v == 1)
@@ -125,6 +123,8 @@ func assignDaemonConfigValue(cfg *DaemonConfig, key, value string) {
 		cfg.RedisAuth = value
 	case "OJ_REDISQNAME":
 		cfg.RedisQName = value
+	case "OJ_REDIS_REQUEUE_TIMEOUT":
+		cfg.RedisRequeue, _ = strconv.Atoi(value)
 	case "OJ_UDP_ENABLE":
 		cfg.UDPEnable, _ = strconv.ParseBool(value)
 	case "OJ_UDP_SERVER":
@@ -141,5 +141,39 @@ func assignDaemonConfigValue(cfg *DaemonConfig, key, value string) {
 		cfg.InternalClient = (v == 1)
 	case "OJ_TURBO_MODE":
 		cfg.TurboMode, _ = strconv.Atoi(value)
+	case "OJ_METRICS_ADDR":
+		cfg.MetricsAddr = value
+	case "OJ_RECOVER_AGE":
+		cfg.RecoverAge, _ = strconv.Atoi(value)
+	case "OJ_DRAIN_TIMEOUT":
+		cfg.DrainTimeout, _ = strconv.Atoi(value)
+	case "OJ_JUDGE_TIMEOUT":
+		cfg.JudgeTimeout, _ = strconv.Atoi(value)
+	case "OJ_CLIENT_RETRIES":
+		cfg.ClientRetries, _ = strconv.Atoi(value)
+	case "OJ_PRIORITY_STARVATION":
+		cfg.StarveLimit, _ = strconv.Atoi(value)
+	case "OJ_FAIR_SHARE":
+		cfg.FairShare, _ = strconv.Atoi(value)
+	case "OJ_USER_MAX_RUNNING":
+		cfg.UserSlots, _ = strconv.Atoi(value)
+	case "OJ_LEASE_TIME":
+		cfg.LeaseTime, _ = strconv.Atoi(value)
+	case "OJ_MEMORY_ADMISSION":
+		v, _ := strconv.Atoi(value)
+		cfg.MemoryAdmission = (v == 1)
+	case "OJ_MEMORY_RESERVE":
+		cfg.MemoryReserve, _ = strconv.Atoi(value)
+	case "OJ_LOAD_LIMIT":
+		cfg.LoadLimit, _ = strconv.ParseFloat(value, 64)
+	case "OJ_SITE_WEIGHT":
+		cfg.SiteWeight, _ = strconv.Atoi(value)
+	case "OJ_LANG_BREAKER":
+		cfg.LangBreaker, _ = strconv.Atoi(value)
+	case "OJ_CTL_SOCKET":
+		cfg.CtlSocket = value
+		if value != "" && !filepath.IsAbs(value) {
+			cfg.CtlSocket = filepath.Join(cfg.OJHome, value)
+		}
 	}
 }
@@ -0,0 +1,93 @@
+//go:build linux
+
+package daemon
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sempr/hustoj-go/pkg/cpuset"
+	"golang.org/x/sys/unix"
+)
+
+// confineHousekeeping keeps the daemon and the processes in OJ_CPU_CONFINE,
+// usually the local database, off the CPUs runner slots are pinned to.
+func confineHousekeeping(cfg *DaemonConfig) {
+	if !cfg.CPU.Pin {
+		return
+	}
+	plan, err := cpuset.NewPlan(&cfg.CPU)
+	if err != nil {
+		slog.Warn("Could not plan CPU pinning, runs are not pinned", "err", err)
+		return
+	}
+	if cfg.MaxRunning > len(plan.Runners) {
+		slog.Warn("More runner slots than dedicated CPUs, some slots share a CPU",
+			"slots", cfg.MaxRunning, "cpus", len(plan.Runners))
+	}
+
+	cpus := plan.Housekeeping(cfg.MaxRunning)
+	if len(cpus) == 0 {
+		slog.Warn("No CPU left for housekeeping, set OJ_CPU_RESERVED")
+		return
+	}
+	slog.Info("Pinning runner slots to dedicated CPUs",
+		"runners", cpuset.FormatList(plan.Runners[:min(cfg.MaxRunning, len(plan.Runners))]),
+		"housekeeping", cpuset.FormatList(cpus))
+
+	if err := setAffinity(os.Getpid(), cpus); err != nil {
+		slog.Warn("Could not confine the daemon", "err", err)
+	}
+	for _, name := range cfg.CPU.Confine {
+		for _, pid := range findProcesses(name) {
+			if err := setAffinity(pid, cpus); err != nil {
+				slog.Warn("Could not confine process", "name", name, "pid", pid, "err", err)
+				continue
+			}
+			slog.Info("Confined process to housekeeping CPUs", "name", name, "pid", pid)
+		}
+	}
+}
+
+// setAffinity restricts every thread of pid to cpus.
+func setAffinity(pid int, cpus []int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+
+	tasks, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", pid))
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := unix.SchedSetaffinity(tid, &set); err != nil && err != unix.ESRCH {
+			return fmt.Errorf("thread %d: %w", tid, err)
+		}
+	}
+	return nil
+}
+
+// findProcesses returns the pids whose command name is name.
+func findProcesses(name string) []int {
+	comms, _ := filepath.Glob("/proc/[0-9]*/comm")
+	var pids []int
+	for _, comm := range comms {
+		data, err := os.ReadFile(comm)
+		if err != nil || strings.TrimSpace(string(data)) != name {
+			continue
+		}
+		if pid, err := strconv.Atoi(filepath.Base(filepath.Dir(comm))); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
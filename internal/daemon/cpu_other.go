@@ -0,0 +1,12 @@
+//go:build !linux
+
+package daemon
+
+import "log/slog"
+
+// confineHousekeeping is a no-op: CPU pinning needs Linux cgroups.
+func confineHousekeeping(cfg *DaemonConfig) {
+	if cfg.CPU.Pin {
+		slog.Warn("CPU pinning is not supported on this OS. Running unpinned.")
+	}
+}
@@ -0,0 +1,103 @@
+package daemon
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/sempr/hustoj-go/internal/client"
+	"github.com/sempr/hustoj-go/internal/sandbox"
+	"github.com/sempr/hustoj-go/pkg/constants"
+	"github.com/sempr/hustoj-go/pkg/events"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+	"github.com/sempr/hustoj-go/pkg/models"
+)
+
+// judged reports whether a solution got its final verdict. The report of
+// the client is trusted first; a client that crashed before sending one may
+// still have written the verdict, so the database is asked as well. A
+// backend that cannot tell leaves only what the daemon knows: the client
+// exited without a final report, so the solution counts as not judged.
+func (w *Worker) judged(solutionID int, report *models.JudgeReport) bool {
+	if report != nil && report.Result >= OJ_AC {
+		return true
+	}
+	if w.shared == nil {
+		return true // Nothing to check against
+	}
+	solution, err := w.shared.db.GetSolution(solutionID)
+	if err != nil {
+		slog.Warn("Could not check solution state", "solution_id", solutionID, "err", err)
+		return false
+	}
+	return solution.Result != interfaces.ResultUnknown && solution.Result >= OJ_AC
+}
+
+// handleCrash deals with a client that exited without a final verdict. The
+// solution is requeued up to OJ_CLIENT_RETRIES times, then marked as a
+// system error with the client output in runtimeinfo. It returns false if
+// the job went back to the queue and must not be acknowledged.
+func (w *Worker) handleCrash(clientID int, job *runningJob, output string) bool {
+	w.cleanupRunner(clientID, job.solutionID)
+
+	w.attempts[job.solutionID]++
+	attempts := w.attempts[job.solutionID]
+	if requeuer, ok := w.fetcher.(JobRequeuer); ok && attempts <= w.cfg.ClientRetries {
+		err := requeuer.Requeue(job.solutionID)
+		if err == nil {
+			slog.Warn("Client exited without a verdict, requeued solution",
+				"solution_id", job.solutionID, "client_id", clientID, "attempt", attempts)
+			return false
+		}
+		slog.Error("Could not requeue solution", "solution_id", job.solutionID, "err", err)
+	}
+
+	delete(w.attempts, job.solutionID)
+	slog.Error("Client exited without a verdict, giving up",
+		"solution_id", job.solutionID, "client_id", clientID, "attempts", attempts)
+	details := fmt.Sprintf("System Error: the judge client on %s exited without a verdict (attempt %d).\n",
+		w.cfg.Judger, attempts)
+	if output != "" {
+		details += "\nClient output:\n" + output
+	}
+	w.recordSystemError(job, details)
+	return true
+}
+
+// cleanupRunner removes the mounts and cgroups a killed or crashed client
+// could not clean up itself.
+func (w *Worker) cleanupRunner(clientID, solutionID int) {
+	if err := client.CleanupRunDir(w.cfg.OJHome, w.cfg.Namespace(), strconv.Itoa(clientID)); err != nil {
+		slog.Warn("Could not clean up runner directory", "client_id", clientID, "err", err)
+	}
+	if _, err := sandbox.CleanupSolutionCgroups(w.cfg.Namespace(), solutionID); err != nil {
+		slog.Warn("Could not clean up cgroups", "solution_id", solutionID, "err", err)
+	}
+}
+
+// recordSystemError sets the verdict of a solution to OJ_SE and explains
+// why in runtimeinfo.
+func (w *Worker) recordSystemError(job *runningJob, details string) {
+	if w.shared == nil {
+		return
+	}
+	solutionID := job.solutionID
+	db := w.shared.db
+	if err := db.UpdateSolution(solutionID, OJ_SE, 0, 0, 0); err != nil {
+		slog.Error("Could not record system error", "solution_id", solutionID, "err", err)
+		return
+	}
+	if err := db.AddRuntimeInfo(solutionID, details); err != nil {
+		slog.Warn("Could not write runtime info", "solution_id", solutionID, "err", err)
+	}
+	w.shared.publish(events.Event{
+		Type:       events.SystemError,
+		SolutionID: solutionID,
+		UserID:     job.userID,
+		Language:   job.language,
+		Judger:     w.cfg.Judger,
+		Result:     OJ_SE,
+		ResultName: constants.GetOJResultName(OJ_SE),
+		Message:    details,
+	})
+}
@@ -0,0 +1,107 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+	"github.com/sempr/hustoj-go/pkg/models"
+)
+
+func newCrashTestWorker(t *testing.T, retries int) (*Worker, *stubFetcher, *stubDB) {
+	t.Helper()
+	f := &stubFetcher{}
+	db := newStubDB(interfaces.Problem{ID: 1000, TimeLimit: 1})
+	cfg := &DaemonConfig{
+		JudgeConfig:   &config.JudgeConfig{OJHome: t.TempDir(), Judger: "j1"},
+		MaxRunning:    1,
+		ClientRetries: retries,
+	}
+	w := NewWorker(cfg, f)
+	w.shared = &sharedClient{db: db}
+	return w, f, db
+}
+
+// crash runs solutionID in slot 0 and lets its client exit without a verdict.
+func crash(w *Worker, solutionID int, output string) {
+	w.running[0] = &runningJob{solutionID: solutionID, cancel: func() {}}
+	w.finish(jobResult{clientID: 0, output: output})
+}
+
+func TestCrashedClientIsRetried(t *testing.T) {
+	w, f, db := newCrashTestWorker(t, 1)
+
+	crash(w, 600, "panic: boom")
+
+	if len(f.requeued) != 1 || f.requeued[0] != 600 {
+		t.Errorf("requeued = %v; want [600]", f.requeued)
+	}
+	if len(f.acked) != 0 {
+		t.Errorf("acked = %v; requeued job must not be acknowledged", f.acked)
+	}
+	if _, ok := db.results[600]; ok {
+		t.Error("verdict written although the solution was retried")
+	}
+}
+
+func TestCrashedClientGivesUpWithSystemError(t *testing.T) {
+	w, f, db := newCrashTestWorker(t, 1)
+
+	crash(w, 601, "first")
+	crash(w, 601, "open etc/langs/cpp.lang.toml: no such file or directory")
+
+	if len(f.requeued) != 1 {
+		t.Errorf("requeued = %v; want a single retry", f.requeued)
+	}
+	if db.results[601] != OJ_SE {
+		t.Errorf("result = %d; want OJ_SE", db.results[601])
+	}
+	if !strings.Contains(db.runtimeInfo[601], "cpp.lang.toml") {
+		t.Errorf("runtime info %q lacks the client output", db.runtimeInfo[601])
+	}
+	if len(f.acked) != 1 {
+		t.Errorf("acked = %v; want the failed job acknowledged", f.acked)
+	}
+	if len(w.attempts) != 0 {
+		t.Errorf("attempts = %v; want it cleared", w.attempts)
+	}
+}
+
+func TestJudgedSolutionIsNotRetried(t *testing.T) {
+	w, f, db := newCrashTestWorker(t, 1)
+
+	w.running[0] = &runningJob{solutionID: 602, cancel: func() {}}
+	w.finish(jobResult{clientID: 0, report: &models.JudgeReport{Result: OJ_AC}})
+
+	// The client died after writing the verdict but before its report.
+	db.results[603] = 6
+	crash(w, 603, "")
+
+	if len(f.requeued) != 0 {
+		t.Errorf("requeued = %v; want none", f.requeued)
+	}
+	if len(f.acked) != 2 {
+		t.Errorf("acked = %v; want both jobs", f.acked)
+	}
+}
+
+func TestCrashWithUnknownResultIsRetried(t *testing.T) {
+	w, f, db := newCrashTestWorker(t, 1)
+
+	// The HTTP judge API cannot tell the verdict, but the client exited
+	// without a final report.
+	db.results[604] = interfaces.ResultUnknown
+	crash(w, 604, "")
+	if len(f.requeued) != 1 || f.requeued[0] != 604 {
+		t.Errorf("requeued = %v; want [604]", f.requeued)
+	}
+
+	crash(w, 604, "")
+	if db.results[604] != OJ_SE {
+		t.Errorf("result = %d; want OJ_SE after the retries", db.results[604])
+	}
+	if len(f.acked) != 1 {
+		t.Errorf("acked = %v; want the failed job acknowledged", f.acked)
+	}
+}
@@ -0,0 +1,407 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+// ctlTimeout bounds how long a control request waits for the worker, which
+// only answers between scheduling rounds.
+const ctlTimeout = 5 * time.Second
+
+// CtlSocketPath returns the control socket of instance in ojHome.
+func CtlSocketPath(ojHome, instance string) string {
+	return filepath.Join(ojHome, "etc", "judged-go"+config.InstanceSuffix(instance)+".sock")
+}
+
+// ctlRequest is one line of JSON sent by `hustoj-go ctl`.
+type ctlRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Site    string   `json:"site,omitempty"` // Empty for all sites
+}
+
+// ctlResponse answers a ctlRequest.
+type ctlResponse struct {
+	OK      bool         `json:"ok"`
+	Error   string       `json:"error,omitempty"`
+	Message string       `json:"message,omitempty"`
+	Status  *ctlStatus   `json:"status,omitempty"`
+	Sites   []*ctlStatus `json:"sites,omitempty"` // Status of each site of a multi-site daemon
+}
+
+// ctlStatus describes the state of the worker for the status command.
+type ctlStatus struct {
+	Site       string            `json:"site,omitempty"`
+	PID        int               `json:"pid"`
+	State      string            `json:"state"` // running, paused or draining
+	UptimeSec  int64             `json:"uptime_sec"`
+	UsedSlots  int               `json:"used_slots"`
+	MaxRunning int               `json:"max_running"`
+	Queued     int               `json:"queued"`
+	Health     string            `json:"health"`
+	Disabled   []int             `json:"disabled_languages,omitempty"` // Languages held back by their breaker
+	Jobs       []ctlJob          `json:"jobs"`
+	Config     map[string]string `json:"config"`
+}
+
+type ctlJob struct {
+	SolutionID int    `json:"solution_id"`
+	ClientID   int    `json:"client_id"`
+	UserID     string `json:"user_id,omitempty"`
+	Language   int    `json:"language"`
+	ElapsedSec int64  `json:"elapsed_sec"`
+}
+
+// ctlCall hands a request to the worker loop, which owns all state the
+// commands read or change.
+type ctlCall struct {
+	req   ctlRequest
+	reply chan ctlResponse
+}
+
+// ctlServer accepts control connections on a Unix socket and hands them to
+// the worker of each site.
+type ctlServer struct {
+	ln      net.Listener
+	path    string
+	workers []*Worker
+}
+
+// newCtlServer listens on path. A socket left behind by a crashed daemon is
+// replaced; the PID file lock guarantees no other daemon is using it.
+func newCtlServer(path string, workers []*Worker) (*ctlServer, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not remove stale socket %s: %w", path, err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &ctlServer{ln: ln, path: path, workers: workers}, nil
+}
+
+// serve accepts connections until the server is closed.
+func (s *ctlServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("Control socket accept failed", "err", err)
+			continue
+		}
+		go s.handle(conn)
+	}
+}
+
+// close stops accepting connections and removes the socket.
+func (s *ctlServer) close() {
+	s.ln.Close()
+	os.Remove(s.path)
+}
+
+func (s *ctlServer) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * ctlTimeout))
+
+	var req ctlRequest
+	var resp ctlResponse
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("bad request: %v", err)
+	} else {
+		resp = s.dispatch(req)
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// dispatch sends a request to the site it names, or to every site. Status,
+// pause, resume and drain apply to all sites at once; changing a setting
+// or killing a solution needs a site when there are several.
+func (s *ctlServer) dispatch(req ctlRequest) ctlResponse {
+	workers := s.workers
+	if req.Site != "" {
+		workers = nil
+		for _, w := range s.workers {
+			if w.site == req.Site {
+				workers = append(workers, w)
+			}
+		}
+		if len(workers) == 0 {
+			return ctlResponse{Error: fmt.Sprintf("unknown site %q", req.Site)}
+		}
+	}
+	if len(workers) == 1 {
+		return call(workers[0].ctl, req)
+	}
+	if req.Command == "set" || req.Command == "kill" {
+		return ctlResponse{Error: "the daemon serves several sites, choose one with --site"}
+	}
+
+	merged := ctlResponse{OK: true}
+	var messages, errs []string
+	for _, w := range workers {
+		resp := call(w.ctl, req)
+		switch {
+		case !resp.OK:
+			errs = append(errs, w.site+": "+resp.Error)
+		case resp.Status != nil:
+			merged.Sites = append(merged.Sites, resp.Status)
+		default:
+			messages = append(messages, w.site+": "+resp.Message)
+		}
+	}
+	merged.Message = strings.Join(messages, "\n")
+	if len(errs) > 0 {
+		merged.OK, merged.Error = false, strings.Join(errs, "\n")
+	}
+	return merged
+}
+
+// call hands a request to a worker loop and waits for the answer.
+func call(calls chan<- ctlCall, req ctlRequest) ctlResponse {
+	c := ctlCall{req: req, reply: make(chan ctlResponse, 1)}
+	timeout := time.NewTimer(ctlTimeout)
+	defer timeout.Stop()
+	select {
+	case calls <- c:
+	case <-timeout.C:
+		return ctlResponse{Error: "the daemon is busy, try again"}
+	}
+	select {
+	case resp := <-c.reply:
+		return resp
+	case <-timeout.C:
+		return ctlResponse{Error: "the daemon did not answer in time"}
+	}
+}
+
+// handleCtl executes a control command in the worker loop.
+func (w *Worker) handleCtl(req ctlRequest) ctlResponse {
+	slog.Info("Control command", "command", req.Command, "args", req.Args)
+	switch req.Command {
+	case "status":
+		return ctlResponse{OK: true, Status: w.ctlStatus()}
+	case "pause":
+		w.paused = true
+		return ctlResponse{OK: true, Message: fmt.Sprintf("paused, %d judgements still running", len(w.running))}
+	case "resume":
+		if w.draining {
+			return ctlResponse{Error: "the daemon is draining and cannot resume"}
+		}
+		w.paused = false
+		w.queue.stale = true
+		return ctlResponse{OK: true, Message: "resumed"}
+	case "drain":
+		w.paused, w.draining = true, true
+		return ctlResponse{OK: true, Message: fmt.Sprintf("draining, the daemon exits after %d running judgements", len(w.running))}
+	case "set":
+		return w.ctlSet(req.Args)
+	case "kill":
+		return w.ctlKill(req.Args)
+	}
+	return ctlResponse{Error: fmt.Sprintf("unknown command %q", req.Command)}
+}
+
+func (w *Worker) ctlSet(args []string) ctlResponse {
+	if len(args) != 2 {
+		return ctlResponse{Error: "usage: set running N"}
+	}
+	switch args[0] {
+	case "running":
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return ctlResponse{Error: fmt.Sprintf("invalid slot count %q", args[1])}
+		}
+		if w.pool != nil {
+			return ctlResponse{Error: "OJ_RUNNING slots are shared by all sites; change OJ_RUNNING in judge.conf and reload"}
+		}
+		old := w.cfg.MaxRunning
+		w.cfg.MaxRunning = n
+		w.metrics.maxRunning.Set(float64(n))
+		w.reconfigureFetcher()
+		slog.Info("Changed OJ_RUNNING", "from", old, "to", n)
+		return ctlResponse{OK: true, Message: fmt.Sprintf("running slots changed from %d to %d", old, n)}
+	}
+	return ctlResponse{Error: fmt.Sprintf("unknown setting %q", args[0])}
+}
+
+// ctlKill stops the judgement of a solution. Like a watchdog timeout, the
+// solution is marked as a system error rather than judged again.
+func (w *Worker) ctlKill(args []string) ctlResponse {
+	if len(args) != 1 {
+		return ctlResponse{Error: "usage: kill SOLUTION_ID"}
+	}
+	solutionID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return ctlResponse{Error: fmt.Sprintf("invalid solution id %q", args[0])}
+	}
+	for clientID, job := range w.running {
+		if job.solutionID == solutionID {
+			job.killed, job.killedAt = true, time.Now()
+			job.cancel()
+			return ctlResponse{OK: true, Message: fmt.Sprintf("killed solution %d on client %d", solutionID, clientID)}
+		}
+	}
+	return ctlResponse{Error: fmt.Sprintf("solution %d is not running", solutionID)}
+}
+
+func (w *Worker) ctlStatus() *ctlStatus {
+	state := "running"
+	switch {
+	case w.draining:
+		state = "draining"
+	case w.paused:
+		state = "paused"
+	}
+	_, health := w.health.status()
+	st := &ctlStatus{
+		Site:       w.site,
+		PID:        os.Getpid(),
+		State:      state,
+		UptimeSec:  int64(time.Since(w.started).Seconds()),
+		UsedSlots:  w.usedSlots(),
+		MaxRunning: w.cfg.MaxRunning,
+		Queued:     len(w.queue.jobs),
+		Health:     health,
+		Disabled:   w.breaker.disabledLanguages(),
+		Jobs:       []ctlJob{},
+		Config: map[string]string{
+			"OJ_HOME":            w.cfg.OJHome,
+			"OJ_INSTANCE":        w.cfg.Instance,
+			"OJ_JUDGER_NAME":     w.cfg.Judger,
+			"OJ_RUNNING":         strconv.Itoa(w.cfg.MaxRunning),
+			"OJ_SLEEP_TIME":      strconv.Itoa(w.cfg.SleepTime),
+			"OJ_LANG_SET":        w.cfg.LangSet,
+			"OJ_INTERNAL_CLIENT": strconv.FormatBool(w.cfg.InternalClient),
+			"OJ_JUDGE_TIMEOUT":   strconv.Itoa(w.cfg.JudgeTimeout),
+			"OJ_LANG_BREAKER":    strconv.Itoa(w.cfg.LangBreaker),
+			"backend":            fmt.Sprintf("%T", w.fetcher),
+		},
+	}
+	if w.pool != nil {
+		st.Config["OJ_SITE_WEIGHT"] = strconv.Itoa(w.cfg.SiteWeight)
+	}
+	for clientID, job := range w.running {
+		st.Jobs = append(st.Jobs, ctlJob{
+			SolutionID: job.solutionID,
+			ClientID:   clientID,
+			UserID:     job.userID,
+			Language:   job.language,
+			ElapsedSec: int64(time.Since(job.started).Seconds()),
+		})
+	}
+	slices.SortFunc(st.Jobs, func(a, b ctlJob) int { return a.ClientID - b.ClientID })
+	return st
+}
+
+// recordKilled marks a solution stopped with `hustoj-go ctl kill` as a
+// system error.
+func (w *Worker) recordKilled(clientID int, job *runningJob, output string) {
+	slog.Warn("Judgement killed by operator", "solution_id", job.solutionID, "client_id", clientID)
+
+	w.cleanupRunner(clientID, job.solutionID)
+	details := fmt.Sprintf("System Error: judging was stopped by an operator on judger %s (client %d).\n",
+		w.cfg.Judger, clientID)
+	if output != "" {
+		details += "\nClient output:\n" + output
+	}
+	w.recordSystemError(job, details)
+}
+
+// Ctl sends a command to the daemon listening on socket and prints the
+// answer to out. A non-empty site limits the command to one site of a
+// multi-site daemon.
+func Ctl(socket, site string, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("no command given")
+	}
+	conn, err := net.DialTimeout("unix", socket, ctlTimeout)
+	if err != nil {
+		return fmt.Errorf("could not reach the daemon: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * ctlTimeout))
+
+	if err := json.NewEncoder(conn).Encode(ctlRequest{Command: args[0], Args: args[1:], Site: site}); err != nil {
+		return err
+	}
+	var resp ctlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("could not read the answer: %w", err)
+	}
+	if resp.Status != nil {
+		resp.Sites = append(resp.Sites, resp.Status)
+	}
+	for i, st := range resp.Sites {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		printCtlStatus(out, st)
+	}
+	if resp.Message != "" {
+		fmt.Fprintln(out, resp.Message)
+	}
+	if !resp.OK {
+		return errors.New(resp.Error)
+	}
+	return nil
+}
+
+func printCtlStatus(out io.Writer, st *ctlStatus) {
+	if st.Site != "" {
+		fmt.Fprintf(out, "site %s\n", st.Site)
+	}
+	fmt.Fprintf(out, "pid %d, %s, up %s\n", st.PID, st.State, time.Duration(st.UptimeSec)*time.Second)
+	fmt.Fprintf(out, "slots %d/%d used, %d queued\n", st.UsedSlots, st.MaxRunning, st.Queued)
+	fmt.Fprintf(out, "job queue: %s\n", st.Health)
+	if len(st.Disabled) > 0 {
+		langs := make([]string, len(st.Disabled))
+		for i, langID := range st.Disabled {
+			langs[i] = strconv.Itoa(langID)
+		}
+		fmt.Fprintf(out, "disabled languages: %s (sandbox system errors, probing)\n", strings.Join(langs, ", "))
+	}
+
+	keys := make([]string, 0, len(st.Config))
+	for k := range st.Config {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	fmt.Fprintln(out, "\nconfig:")
+	for _, k := range keys {
+		fmt.Fprintf(out, "  %s=%s\n", k, st.Config[k])
+	}
+
+	if len(st.Jobs) == 0 {
+		fmt.Fprintln(out, "\nno running judgements")
+		return
+	}
+	fmt.Fprintln(out)
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIENT\tSOLUTION\tUSER\tLANGUAGE\tELAPSED")
+	for _, j := range st.Jobs {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%s\n", j.ClientID, j.SolutionID, j.UserID, j.Language,
+			time.Duration(j.ElapsedSec)*time.Second)
+	}
+	tw.Flush()
+}
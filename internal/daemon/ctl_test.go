@@ -0,0 +1,137 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+)
+
+func ctl(t *testing.T, socket string, args ...string) (string, error) {
+	t.Helper()
+	var out strings.Builder
+	err := Ctl(socket, "", args, &out)
+	return out.String(), err
+}
+
+func TestCtlOverSocket(t *testing.T) {
+	home := t.TempDir()
+	socket := filepath.Join(home, "ctl.sock")
+	f := &stubFetcher{}
+	db := newStubDB(interfaces.Problem{ID: 1000, TimeLimit: 1})
+	cfg := &DaemonConfig{
+		JudgeConfig: &config.JudgeConfig{OJHome: home, Judger: "j1"},
+		MaxRunning:  1,
+		SleepTime:   1,
+		CtlSocket:   socket,
+	}
+	w := NewWorker(cfg, f)
+	w.shared = &sharedClient{db: db}
+	startFakeJob(w, 0, 100, time.Hour)
+	stop := startServices(context.Background(), cfg, []*Worker{w})
+
+	stopped := make(chan struct{})
+	go func() {
+		w.Run(context.Background())
+		close(stopped)
+	}()
+	for i := 0; ; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatal("control socket was not created")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	out, err := ctl(t, socket, "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"running", "slots 1/1", "OJ_JUDGER_NAME=j1", "100"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("status does not contain %q:\n%s", want, out)
+		}
+	}
+
+	if _, err := ctl(t, socket, "kill", "101"); err == nil {
+		t.Error("killing a solution that is not running succeeded")
+	}
+	if _, err := ctl(t, socket, "kill", "100"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctl(t, socket, "drain"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("worker did not stop after drain")
+	}
+
+	if db.results[100] != OJ_SE || !strings.Contains(db.runtimeInfo[100], "operator") {
+		t.Errorf("killed solution: result = %d, runtime info %q; want OJ_SE by an operator", db.results[100], db.runtimeInfo[100])
+	}
+	if len(f.acked) != 1 || f.acked[0] != 100 {
+		t.Errorf("acked = %v; want [100]", f.acked)
+	}
+	stop()
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Error("control socket was not removed")
+	}
+}
+
+func TestCtlPauseAndSetRunning(t *testing.T) {
+	f := &stubFetcher{jobs: []int{1}}
+	w := NewWorker(&DaemonConfig{JudgeConfig: &config.JudgeConfig{}, MaxRunning: 2}, f)
+
+	if resp := w.handleCtl(ctlRequest{Command: "pause"}); !resp.OK {
+		t.Fatal(resp.Error)
+	}
+	if n := w.work(); n != 0 || f.fetches != 0 {
+		t.Errorf("paused worker started %d jobs after %d fetches", n, f.fetches)
+	}
+	if st := w.ctlStatus(); st.State != "paused" {
+		t.Errorf("state = %q; want paused", st.State)
+	}
+
+	if resp := w.handleCtl(ctlRequest{Command: "set", Args: []string{"running", "4"}}); !resp.OK || w.cfg.MaxRunning != 4 {
+		t.Errorf("set running 4: %+v, MaxRunning = %d", resp, w.cfg.MaxRunning)
+	}
+	for _, bad := range [][]string{{"running", "0"}, {"running", "x"}, {"sleep", "1"}, {"running"}} {
+		if resp := w.handleCtl(ctlRequest{Command: "set", Args: bad}); resp.OK {
+			t.Errorf("set %v succeeded", bad)
+		}
+	}
+	if w.cfg.MaxRunning != 4 {
+		t.Errorf("MaxRunning = %d after invalid sets; want 4", w.cfg.MaxRunning)
+	}
+
+	w.queue.stale = false
+	if resp := w.handleCtl(ctlRequest{Command: "resume"}); !resp.OK || w.paused || !w.queue.stale {
+		t.Errorf("resume: %+v, paused = %v, stale = %v", resp, w.paused, w.queue.stale)
+	}
+	w.handleCtl(ctlRequest{Command: "drain"})
+	if resp := w.handleCtl(ctlRequest{Command: "resume"}); resp.OK {
+		t.Error("resume succeeded while draining")
+	}
+}
+
+func TestCtlSetRunningRefusedForSharedSlots(t *testing.T) {
+	w := NewWorker(&DaemonConfig{JudgeConfig: &config.JudgeConfig{Site: "school"}, MaxRunning: 2}, &stubFetcher{})
+	w.joinPool(newSlotPool(2))
+
+	resp := w.handleCtl(ctlRequest{Command: "set", Args: []string{"running", "4"}})
+	if resp.OK || !strings.Contains(resp.Error, "shared by all sites") {
+		t.Errorf("set running 4 on a site = %+v; want it refused", resp)
+	}
+	if w.cfg.MaxRunning != 2 {
+		t.Errorf("MaxRunning = %d; want 2", w.cfg.MaxRunning)
+	}
+}
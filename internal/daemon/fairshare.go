@@ -0,0 +1,112 @@
+package daemon
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+)
+
+// OJ_FAIR_SHARE modes.
+const (
+	fairShareOff     = 0
+	fairShareUser    = 1 // Round-robin by user
+	fairShareContest = 2 // Round-robin by contest, then by user within it
+)
+
+// fairPolicy interleaves the jobs of one priority class round-robin by
+// user, so one user's burst of submissions cannot push everybody else back.
+// The turn passes on across calls: slots free up one at a time, so users
+// are ordered by when they last had a job started, not by their oldest
+// pending job. Jobs of different classes keep the order of the wrapped
+// policy.
+type fairPolicy struct {
+	PriorityPolicy
+	byContest bool
+	turn      int
+	served    map[string]int // Turn at which a user or contest last had a job started
+}
+
+func (p *fairPolicy) Order(jobs []Job) []Job {
+	ordered := p.PriorityPolicy.Order(jobs)
+	p.forgetIdle(ordered)
+	for start := 0; start < len(ordered); {
+		end := start + 1
+		for end < len(ordered) && ordered[end].class() == ordered[start].class() {
+			end++
+		}
+		run := roundRobin(ordered[start:end], userKey, p.served)
+		if p.byContest {
+			run = roundRobin(run, contestKey, p.served)
+		}
+		copy(ordered[start:end], run)
+		start = end
+	}
+	return ordered
+}
+
+func (p *fairPolicy) Started(job Job) {
+	p.PriorityPolicy.Started(job)
+	if p.served == nil {
+		p.served = make(map[string]int)
+	}
+	p.turn++
+	p.served[userKey(job)] = p.turn
+	if p.byContest {
+		p.served[contestKey(job)] = p.turn
+	}
+}
+
+// forgetIdle drops the turns of users and contests without pending jobs, so
+// the map does not grow with every user ever seen.
+func (p *fairPolicy) forgetIdle(jobs []Job) {
+	pending := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		pending[userKey(job)], pending[contestKey(job)] = true, true
+	}
+	for k := range p.served {
+		if !pending[k] {
+			delete(p.served, k)
+		}
+	}
+}
+
+func userKey(j Job) string    { return "user:" + j.UserID }
+func contestKey(j Job) string { return "contest:" + strconv.Itoa(j.ContestID) }
+
+// roundRobin takes one job per key in turn. Keys that were served longest
+// ago, according to served, go first; ties keep the order of first
+// appearance. The order of jobs with the same key is kept.
+func roundRobin(jobs []Job, key func(Job) string, served map[string]int) []Job {
+	var keys []string
+	groups := make(map[string][]Job)
+	for _, job := range jobs {
+		k := key(job)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], job)
+	}
+	slices.SortStableFunc(keys, func(a, b string) int { return cmp.Compare(served[a], served[b]) })
+
+	result := make([]Job, 0, len(jobs))
+	for len(result) < len(jobs) {
+		for _, k := range keys {
+			if group := groups[k]; len(group) > 0 {
+				result = append(result, group[0])
+				groups[k] = group[1:]
+			}
+		}
+	}
+	return result
+}
+
+// userSlots counts the slots held by userID.
+func (w *Worker) userSlots(userID string) int {
+	n := 0
+	for _, job := range w.running {
+		if job.userID == userID {
+			n++
+		}
+	}
+	return n
+}
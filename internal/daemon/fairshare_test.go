@@ -0,0 +1,78 @@
+package daemon
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFairPolicyInterleavesUsers(t *testing.T) {
+	p := &fairPolicy{PriorityPolicy: &classPolicy{}}
+	jobs := []Job{
+		{SolutionID: 1, UserID: "spam"},
+		{SolutionID: 2, UserID: "spam"},
+		{SolutionID: 3, UserID: "spam"},
+		{SolutionID: 4, UserID: "alice"},
+		{SolutionID: 5, UserID: "bob"},
+		{SolutionID: 6, UserID: "alice", InContest: true},
+		{SolutionID: 7, UserID: "spam", Rejudge: true},
+	}
+
+	// Contest first, then practice round-robin, then rejudges.
+	if got := solutionIDs(p.Order(jobs)); got != "[6 1 4 5 2 3 7]" {
+		t.Errorf("Order = %v; want [6 1 4 5 2 3 7]", got)
+	}
+}
+
+func TestFairPolicyByContest(t *testing.T) {
+	p := &fairPolicy{PriorityPolicy: &classPolicy{}, byContest: true}
+	jobs := []Job{
+		{SolutionID: 1, UserID: "a", ContestID: 10},
+		{SolutionID: 2, UserID: "b", ContestID: 10},
+		{SolutionID: 3, UserID: "a", ContestID: 10},
+		{SolutionID: 4, UserID: "c", ContestID: 20},
+		{SolutionID: 5, UserID: "c", ContestID: 20},
+	}
+
+	if got := solutionIDs(p.Order(jobs)); got != "[1 4 2 5 3]" {
+		t.Errorf("Order = %v; want [1 4 2 5 3]", got)
+	}
+}
+
+func TestUserSlots(t *testing.T) {
+	w := NewWorker(&DaemonConfig{MaxRunning: 3, UserSlots: 1}, &stubFetcher{})
+	w.running[0] = &runningJob{solutionID: 1, userID: "spam"}
+	w.running[1] = &runningJob{solutionID: 2, userID: "spam"}
+	w.running[2] = &runningJob{solutionID: 3, userID: "alice"}
+
+	if n := w.userSlots("spam"); n != 2 {
+		t.Errorf("userSlots(spam) = %d; want 2", n)
+	}
+	if n := w.userSlots("bob"); n != 0 {
+		t.Errorf("userSlots(bob) = %d; want 0", n)
+	}
+}
+
+func TestFairPolicyPassesTheTurnAcrossCalls(t *testing.T) {
+	p := &fairPolicy{PriorityPolicy: &classPolicy{}}
+	queue := []Job{
+		{SolutionID: 1, UserID: "spam"},
+		{SolutionID: 2, UserID: "spam"},
+		{SolutionID: 3, UserID: "spam"},
+		{SolutionID: 4, UserID: "spam"},
+		{SolutionID: 5, UserID: "alice"},
+		{SolutionID: 6, UserID: "bob"},
+		{SolutionID: 7, UserID: "alice"},
+	}
+
+	// One slot frees up at a time: start the first job of every Order.
+	var started []int
+	for len(queue) > 0 {
+		next := p.Order(queue)[0]
+		p.Started(next)
+		started = append(started, next.SolutionID)
+		queue = slices.DeleteFunc(queue, func(j Job) bool { return j.SolutionID == next.SolutionID })
+	}
+	if got, want := started, []int{1, 5, 6, 2, 7, 3, 4}; !slices.Equal(got, want) {
+		t.Errorf("started %v; want %v", got, want)
+	}
+}
@@ -4,10 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/repository"
 )
 
 const prefetchMultiplier = 80
@@ -19,27 +26,68 @@ type JobFetcher interface {
 	Close() error
 }
 
+// JobAcker is implemented by fetchers that track in-flight jobs and need to
+// be told when a judgement has finished.
+type JobAcker interface {
+	Ack(solutionID int) error
+}
+
+// JobRequeuer is implemented by fetchers that can hand an unfinished job
+// back to the queue for another judger.
+type JobRequeuer interface {
+	Requeue(solutionID int) error
+}
+
+// JobReconfigurer is implemented by fetchers that can apply a reloaded
+// OJ_LANG_SET and OJ_RUNNING without reconnecting.
+type JobReconfigurer interface {
+	Reconfigure(cfg *DaemonConfig)
+}
+
+// JobRecoverer is implemented by fetchers that can find jobs orphaned by a
+// previous crash of this judger and put them back in the queue.
+type JobRecoverer interface {
+	Recover() (int, error)
+}
+
 // NewFetcher is a factory for creating the appropriate JobFetcher based on the config.
 func NewFetcher(cfg *DaemonConfig) (JobFetcher, error) {
-	if cfg.HTTPJudge {
-		// HTTP implementation would go here
-		return nil, fmt.Errorf("HTTP fetcher is not implemented")
+	if cfg.HTTP.Enable {
+		return NewHTTPFetcher(cfg)
 	}
 	if cfg.RedisEnable {
 		return NewRedisFetcher(cfg)
 	}
+	if cfg.Database.Driver == "sqlite" {
+		return NewSQLiteFetcher(cfg)
+	}
 	return NewMySQLFetcher(cfg)
 }
 
+// openJobDB connects to the OJ database of cfg with the configured driver.
+func openJobDB(cfg *config.DatabaseConfig) (*sql.DB, error) {
+	if cfg.Driver == "sqlite" {
+		return repository.OpenSQLite(cfg.Path)
+	}
+	return openMySQL(cfg)
+}
+
 // --- MySQL Fetcher ---
 type MySQLFetcher struct {
-	db          *sql.DB
-	selectQuery string
+	db           *sql.DB
+	mu           sync.Mutex // Guards the queries, which change on reload
+	selectQuery  string
+	countQuery   string
+	judger       string
+	recoverQuery string
+	recoverAge   int
+	lease        *leaseKeeper // Set when OJ_LEASE_TIME coordinates judgers
 }
 
-func NewMySQLFetcher(cfg *DaemonConfig) (*MySQLFetcher, error) {
+// openMySQL connects to the OJ database of cfg.
+func openMySQL(cfg *config.DatabaseConfig) (*sql.DB, error) {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		cfg.Database.User, cfg.Database.Password, cfg.Database.Host, cfg.Database.Port, cfg.Database.Name)
+		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
 
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
@@ -50,31 +98,86 @@ func NewMySQLFetcher(cfg *DaemonConfig) (*MySQLFetcher, error) {
 	db.SetMaxIdleConns(10)
 
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	_, err = db.Exec("SET NAMES utf8")
+	if _, err := db.Exec("SET NAMES utf8"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func NewMySQLFetcher(cfg *DaemonConfig) (*MySQLFetcher, error) {
+	db, err := openMySQL(&cfg.Database)
 	if err != nil {
 		return nil, err
 	}
 
+	f := &MySQLFetcher{
+		db:         db,
+		judger:     cfg.Judger,
+		recoverAge: cfg.RecoverAge,
+	}
+	f.Reconfigure(cfg)
+	if cfg.LeaseTime > 0 {
+		if cfg.TotalJudges > 1 {
+			slog.Info("Lease coordination enabled, ignoring OJ_TOTAL/OJ_MOD")
+		}
+		f.lease = newLeaseKeeper(db, cfg.Judger, time.Duration(cfg.LeaseTime)*time.Second)
+		go f.lease.run()
+	}
+	return f, nil
+}
+
+// Reconfigure builds the queries for the languages and slots of cfg.
+func (f *MySQLFetcher) Reconfigure(cfg *DaemonConfig) {
 	prefetchLimit := prefetchMultiplier * cfg.MaxRunning
-	var query string
-	if cfg.TotalJudges <= 1 {
-		query = fmt.Sprintf(
-			"SELECT solution_id FROM solution WHERE language in (%s) and result<2 ORDER BY result, solution_id limit %d",
-			cfg.LangSet, prefetchLimit)
-	} else {
-		query = fmt.Sprintf(
-			"SELECT solution_id FROM solution WHERE language in (%s) and result<2 and MOD(solution_id,%d)=%d ORDER BY result, solution_id ASC limit %d",
-			cfg.LangSet, cfg.TotalJudges, cfg.JudgeMod, prefetchLimit)
+	pending := pendingCondition(cfg)
+	query := fmt.Sprintf("SELECT solution_id FROM solution WHERE %s ORDER BY result, solution_id ASC limit %d",
+		pending, prefetchLimit)
+
+	// Solutions stuck in OJ_CI/OJ_RI that this judger owns, or that any
+	// judger abandoned more than recoverAge seconds ago.
+	recoverQuery := fmt.Sprintf(
+		"UPDATE solution SET result=%d WHERE result IN (2,3) AND language IN (%s) AND (judger=? OR (?>0 AND judgetime < NOW() - INTERVAL ? SECOND))",
+		OJ_WT1, cfg.LangSet)
+	if cfg.TotalJudges > 1 && cfg.LeaseTime <= 0 {
+		recoverQuery += fmt.Sprintf(" AND MOD(solution_id,%d)=%d", cfg.TotalJudges, cfg.JudgeMod)
 	}
 
-	return &MySQLFetcher{db: db, selectQuery: query}, nil
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.selectQuery = query
+	f.countQuery = "SELECT COUNT(*) FROM solution WHERE " + pending
+	f.recoverQuery = recoverQuery
+}
+
+// query returns one of the queries under the lock.
+func (f *MySQLFetcher) query(q *string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *q
+}
+
+// pendingCondition selects the solutions this judger may take: new and
+// rejudged ones of its languages, either from its OJ_MOD share or, with
+// leases, from everybody plus those whose lease has expired.
+func pendingCondition(cfg *DaemonConfig) string {
+	if cfg.LeaseTime > 0 {
+		return fmt.Sprintf("language in (%s) and (result<2 or (result in (2,3) and judgetime < NOW() - INTERVAL %d SECOND))",
+			cfg.LangSet, cfg.LeaseTime)
+	}
+	pending := fmt.Sprintf("language in (%s) and result<2", cfg.LangSet)
+	if cfg.TotalJudges > 1 {
+		pending += fmt.Sprintf(" and MOD(solution_id,%d)=%d", cfg.TotalJudges, cfg.JudgeMod)
+	}
+	return pending
 }
 
 func (f *MySQLFetcher) GetJobs(maxJobs int) ([]int, error) {
-	rows, err := f.db.Query(f.selectQuery)
+	rows, err := f.db.Query(f.query(&f.selectQuery))
 	if err != nil {
 		return nil, fmt.Errorf("error querying for jobs: %w", err)
 	}
@@ -96,9 +199,12 @@ func (f *MySQLFetcher) CheckOut(solutionID int, result int) (bool, error) {
 	if f.db == nil {
 		return true, nil
 	}
-	query := `UPDATE solution SET result=?, time=0, memory=0, judgetime=NOW() 
+	if f.lease != nil {
+		return f.lease.claim(solutionID, result)
+	}
+	query := `UPDATE solution SET result=?, time=0, memory=0, judgetime=NOW(), judger=?
               WHERE solution_id=? and result<2 LIMIT 1`
-	res, err := f.db.Exec(query, result, solutionID)
+	res, err := f.db.Exec(query, result, f.judger, solutionID)
 	if err != nil {
 		return false, err
 	}
@@ -106,16 +212,231 @@ func (f *MySQLFetcher) CheckOut(solutionID int, result int) (bool, error) {
 	return rowsAffected > 0, err
 }
 
+// Ack stops renewing the lease of a finished solution.
+func (f *MySQLFetcher) Ack(solutionID int) error {
+	if f.lease != nil {
+		f.lease.release(solutionID)
+	}
+	return nil
+}
+
+// QueueLength counts the pending solutions this judger would pick up.
+func (f *MySQLFetcher) QueueLength() (int, error) {
+	var n int
+	err := f.db.QueryRow(f.query(&f.countQuery)).Scan(&n)
+	return n, err
+}
+
+// Describe reports which pending solutions are rejudges or belong to a
+// running contest.
+func (f *MySQLFetcher) Describe(solutionIDs []int) ([]Job, error) {
+	return describeJobs(f.db, solutionIDs)
+}
+
+// Requeue resets a solution that was checked out but not judged to OJ_WT1.
+func (f *MySQLFetcher) Requeue(solutionID int) error {
+	if f.lease != nil {
+		f.lease.release(solutionID)
+	}
+	_, err := f.db.Exec("UPDATE solution SET result=? WHERE solution_id=? AND result IN (2,3)", OJ_WT1, solutionID)
+	return err
+}
+
+// Recover requeues solutions left in OJ_CI/OJ_RI by a previous run of this
+// judger. It must only be called before any job is started.
+func (f *MySQLFetcher) Recover() (int, error) {
+	res, err := f.db.Exec(f.query(&f.recoverQuery), f.judger, f.recoverAge, f.recoverAge)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
 func (f *MySQLFetcher) Close() error {
+	if f.lease != nil {
+		f.lease.stop()
+	}
+	return f.db.Close()
+}
+
+// --- SQLite Fetcher ---
+
+// SQLiteFetcher takes jobs from the solution table of a SQLite database,
+// for single-machine deployments without a MySQL server. Several judgers on
+// the host may share the file by OJ_TOTAL/OJ_MOD; leases are not supported.
+type SQLiteFetcher struct {
+	db           *sql.DB
+	mu           sync.Mutex // Guards the queries, which change on reload
+	selectQuery  string
+	countQuery   string
+	judger       string
+	recoverQuery string
+	recoverAge   int
+}
+
+func NewSQLiteFetcher(cfg *DaemonConfig) (*SQLiteFetcher, error) {
+	db, err := repository.OpenSQLite(cfg.Database.Path)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.LeaseTime > 0 {
+		slog.Warn("OJ_LEASE_TIME is not supported with SQLite, ignoring it")
+	}
+
+	f := &SQLiteFetcher{db: db, judger: cfg.Judger, recoverAge: cfg.RecoverAge}
+	f.Reconfigure(cfg)
+	return f, nil
+}
+
+// Reconfigure builds the queries for the languages and slots of cfg.
+func (f *SQLiteFetcher) Reconfigure(cfg *DaemonConfig) {
+	pending := fmt.Sprintf("language IN (%s) AND result<2", cfg.LangSet)
+	shard := ""
+	if cfg.TotalJudges > 1 {
+		shard = fmt.Sprintf(" AND solution_id%%%d=%d", cfg.TotalJudges, cfg.JudgeMod)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.selectQuery = fmt.Sprintf("SELECT solution_id FROM solution WHERE %s%s ORDER BY result, solution_id LIMIT %d",
+		pending, shard, prefetchMultiplier*cfg.MaxRunning)
+	f.countQuery = "SELECT COUNT(*) FROM solution WHERE " + pending + shard
+	f.recoverQuery = fmt.Sprintf(
+		"UPDATE solution SET result=%d WHERE result IN (2,3) AND language IN (%s) AND (judger=? OR (?>0 AND judgetime < datetime('now', ?)))%s",
+		OJ_WT1, cfg.LangSet, shard)
+}
+
+// query returns one of the queries under the lock.
+func (f *SQLiteFetcher) query(q *string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return *q
+}
+
+func (f *SQLiteFetcher) GetJobs(maxJobs int) ([]int, error) {
+	rows, err := f.db.Query(f.query(&f.selectQuery))
+	if err != nil {
+		return nil, fmt.Errorf("error querying for jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []int
+	for rows.Next() {
+		var solutionID int
+		if err := rows.Scan(&solutionID); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, solutionID)
+	}
+	return jobs, rows.Err()
+}
+
+func (f *SQLiteFetcher) CheckOut(solutionID int, result int) (bool, error) {
+	query := `UPDATE solution SET result=?, time=0, memory=0, judgetime=NOW(), judger=?
+              WHERE solution_id=? AND result<2`
+	res, err := f.db.Exec(query, result, f.judger, solutionID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := res.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+// QueueLength counts the pending solutions this judger would pick up.
+func (f *SQLiteFetcher) QueueLength() (int, error) {
+	var n int
+	err := f.db.QueryRow(f.query(&f.countQuery)).Scan(&n)
+	return n, err
+}
+
+// Describe reports which pending solutions are rejudges or belong to a
+// running contest.
+func (f *SQLiteFetcher) Describe(solutionIDs []int) ([]Job, error) {
+	return describeJobs(f.db, solutionIDs)
+}
+
+// Requeue resets a solution that was checked out but not judged to OJ_WT1.
+func (f *SQLiteFetcher) Requeue(solutionID int) error {
+	_, err := f.db.Exec("UPDATE solution SET result=? WHERE solution_id=? AND result IN (2,3)", OJ_WT1, solutionID)
+	return err
+}
+
+// Recover requeues solutions left in OJ_CI/OJ_RI by a previous run of this
+// judger. It must only be called before any job is started.
+func (f *SQLiteFetcher) Recover() (int, error) {
+	age := fmt.Sprintf("-%d seconds", f.recoverAge)
+	res, err := f.db.Exec(f.query(&f.recoverQuery), f.judger, f.recoverAge, age)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (f *SQLiteFetcher) Close() error {
 	return f.db.Close()
 }
 
 // --- Redis Fetcher ---
+
+// RedisFetcher implements a reliable queue on top of OJ_REDISQNAME. GetJobs
+// only looks at the oldest entries, so the worker can pick by priority;
+// CheckOut moves a job atomically to a per-judger processing list where it
+// stays until Ack is called. A reaper pushes entries that were never
+// acknowledged back to the queue after RedisRequeue seconds. While a job is
+// judged, its judger keeps renewing the time it was taken, so only the jobs
+// of dead judgers are reaped, however long a judgement takes.
 type RedisFetcher struct {
-	client *redis.Client
-	qname  string
+	client     *redis.Client
+	db         *sql.DB // Used to describe jobs, nil without a database
+	qname      string
+	processing string // List of in-flight solution IDs owned by this judger
+	started    string // Hash of solution ID -> unix time it was taken
+	judgers    string // Set of processing lists known to the reapers
+	timeout    time.Duration
+	stop       chan struct{}
+	mu         sync.Mutex
+	held       map[int]bool // Checked out jobs whose time taken is renewed
 }
 
+// claimScript moves ARGV[1] from the queue to the processing list and
+// records when it was taken. It returns 0 if another judger was faster.
+var claimScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], -1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call('LPUSH', KEYS[2], ARGV[1])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+return 1
+`)
+
+// renewScript sets the time taken of the jobs in ARGV[2:] to ARGV[1], but
+// only for those still taken: a reaped job must not come back to the hash.
+var renewScript = redis.NewScript(`
+for i = 2, #ARGV do
+	if redis.call('HEXISTS', KEYS[1], ARGV[i]) == 1 then
+		redis.call('HSET', KEYS[1], ARGV[i], ARGV[1])
+	end
+end
+return 0
+`)
+
+// requeueScript pushes a job back to the queue if it is still in the
+// processing list and was taken before ARGV[2] (0 means unconditionally).
+var requeueScript = redis.NewScript(`
+local t = redis.call('HGET', KEYS[3], ARGV[1])
+if t and tonumber(ARGV[2]) > 0 and tonumber(t) > tonumber(ARGV[2]) then
+	return 0
+end
+if redis.call('LREM', KEYS[2], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HDEL', KEYS[3], ARGV[1])
+redis.call('RPUSH', KEYS[1], ARGV[1])
+return 1
+`)
+
 func NewRedisFetcher(cfg *DaemonConfig) (*RedisFetcher, error) {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%d", cfg.RedisServer, cfg.RedisPort),
@@ -123,33 +444,304 @@ func NewRedisFetcher(cfg *DaemonConfig) (*RedisFetcher, error) {
 		DB:       0,
 	})
 
-	if _, err := rdb.Ping(context.Background()).Result(); err != nil {
+	ctx := context.Background()
+	if _, err := rdb.Ping(ctx).Result(); err != nil {
 		return nil, fmt.Errorf("could not connect to Redis: %w", err)
 	}
 
-	return &RedisFetcher{client: rdb, qname: cfg.RedisQName}, nil
+	f := &RedisFetcher{
+		client:     rdb,
+		qname:      cfg.RedisQName,
+		processing: cfg.RedisQName + ":processing:" + cfg.Judger,
+		started:    cfg.RedisQName + ":started",
+		judgers:    cfg.RedisQName + ":judgers",
+		timeout:    time.Duration(cfg.RedisRequeue) * time.Second,
+		stop:       make(chan struct{}),
+		held:       make(map[int]bool),
+	}
+
+	if err := rdb.SAdd(ctx, f.judgers, f.processing).Err(); err != nil {
+		return nil, fmt.Errorf("could not register judger in Redis: %w", err)
+	}
+
+	// The queue only holds IDs; contest and rejudge state come from the database.
+	if cfg.Database.Host != "" || cfg.Database.Driver == "sqlite" {
+		db, err := openJobDB(&cfg.Database)
+		if err != nil {
+			slog.Warn("Could not connect to the database, judging Redis jobs in queue order", "err", err)
+		}
+		f.db = db
+	}
+
+	if f.timeout > 0 {
+		go f.reap()
+	}
+	return f, nil
 }
 
+// GetJobs returns up to maxJobs of the oldest queued jobs without taking
+// them off the queue.
 func (f *RedisFetcher) GetJobs(maxJobs int) ([]int, error) {
+	// The web frontend LPUSHes, so the oldest jobs are at the tail.
+	vals, err := f.client.LRange(context.Background(), f.qname, int64(-maxJobs), -1).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error getting job from Redis: %w", err)
+	}
+
 	var jobs []int
-	for i := 0; i < maxJobs; i++ {
-		val, err := f.client.RPop(context.Background(), f.qname).Int()
-		if err == redis.Nil {
-			break // Queue is empty
-		}
+	for i := len(vals) - 1; i >= 0; i-- {
+		solutionID, err := strconv.Atoi(vals[i])
 		if err != nil {
-			return nil, fmt.Errorf("error getting job from Redis: %w", err)
+			slog.Warn("Dropping malformed Redis job", "value", vals[i])
+			f.client.LRem(context.Background(), f.qname, 1, vals[i])
+			continue
 		}
-		jobs = append(jobs, val)
+		jobs = append(jobs, solutionID)
 	}
 	return jobs, nil
 }
 
+// CheckOut takes the job off the queue into this judger's processing list.
 func (f *RedisFetcher) CheckOut(solutionID int, result int) (bool, error) {
-	// RPop is atomic, so no explicit checkout is needed.
-	return true, nil
+	n, err := claimScript.Run(context.Background(), f.client,
+		[]string{f.qname, f.processing, f.started},
+		strconv.Itoa(solutionID), time.Now().Unix()).Int()
+	if n == 1 {
+		f.hold(solutionID, true)
+	}
+	return n == 1, err
+}
+
+// hold starts or stops renewing the time a job was taken.
+func (f *RedisFetcher) hold(solutionID int, held bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if held {
+		f.held[solutionID] = true
+	} else {
+		delete(f.held, solutionID)
+	}
+}
+
+// Describe reports which queued solutions are rejudges or belong to a
+// running contest. Without a database every job is a practice submission.
+func (f *RedisFetcher) Describe(solutionIDs []int) ([]Job, error) {
+	if f.db == nil {
+		return plainJobs(solutionIDs), nil
+	}
+	return describeJobs(f.db, solutionIDs)
+}
+
+// QueueLength returns the number of jobs waiting in the Redis queue.
+func (f *RedisFetcher) QueueLength() (int, error) {
+	n, err := f.client.LLen(context.Background(), f.qname).Result()
+	return int(n), err
+}
+
+// Recover requeues everything left in this judger's processing list. Nothing
+// is running yet at startup, so those jobs were orphaned by a crash.
+func (f *RedisFetcher) Recover() (int, error) {
+	return f.requeueList(context.Background(), f.processing, time.Time{})
+}
+
+// Ack removes a finished job from the processing list.
+func (f *RedisFetcher) Ack(solutionID int) error {
+	f.hold(solutionID, false)
+	ctx := context.Background()
+	sid := strconv.Itoa(solutionID)
+	_, err := f.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, f.processing, 1, sid)
+		pipe.HDel(ctx, f.started, sid)
+		return nil
+	})
+	return err
+}
+
+// Requeue moves a job from the processing list back to the queue.
+func (f *RedisFetcher) Requeue(solutionID int) error {
+	f.hold(solutionID, false)
+	return requeueScript.Run(context.Background(), f.client,
+		[]string{f.qname, f.processing, f.started}, strconv.Itoa(solutionID), 0).Err()
 }
 
 func (f *RedisFetcher) Close() error {
+	close(f.stop)
+	if f.db != nil {
+		f.db.Close()
+	}
 	return f.client.Close()
 }
+
+// reap periodically renews the jobs of this judger and requeues stale jobs
+// from every judger's processing list, so work held by a dead judger is
+// picked up by the others.
+func (f *RedisFetcher) reap() {
+	interval := f.timeout / 4
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			if err := f.renew(context.Background()); err != nil {
+				slog.Warn("Could not renew Redis jobs", "err", err)
+			}
+			n, err := f.requeueStale(context.Background())
+			if err != nil {
+				slog.Warn("Redis reaper failed", "err", err)
+			} else if n > 0 {
+				slog.Info("Requeued stale Redis jobs", "count", n)
+			}
+		}
+	}
+}
+
+// renew marks the jobs this judger is judging as taken now.
+func (f *RedisFetcher) renew(ctx context.Context) error {
+	f.mu.Lock()
+	args := []any{time.Now().Unix()}
+	for solutionID := range f.held {
+		args = append(args, strconv.Itoa(solutionID))
+	}
+	f.mu.Unlock()
+	if len(args) == 1 {
+		return nil
+	}
+	return renewScript.Run(ctx, f.client, []string{f.started}, args...).Err()
+}
+
+func (f *RedisFetcher) requeueStale(ctx context.Context) (int, error) {
+	lists, err := f.client.SMembers(ctx, f.judgers).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-f.timeout)
+	total := 0
+	for _, list := range lists {
+		n, err := f.requeueList(ctx, list, cutoff)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// requeueList pushes entries of list taken before cutoff back to the queue.
+// A zero cutoff requeues every entry.
+func (f *RedisFetcher) requeueList(ctx context.Context, list string, cutoff time.Time) (int, error) {
+	sids, err := f.client.LRange(ctx, list, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var before int64
+	if !cutoff.IsZero() {
+		before = cutoff.Unix()
+	}
+
+	total := 0
+	for _, sid := range sids {
+		n, err := requeueScript.Run(ctx, f.client, []string{f.qname, list, f.started}, sid, before).Int()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// --- HTTP Fetcher ---
+type HTTPFetcher struct {
+	session *repository.HTTPSession
+	mu      sync.Mutex
+	langSet string
+}
+
+func NewHTTPFetcher(cfg *DaemonConfig) (*HTTPFetcher, error) {
+	session, err := repository.NewHTTPSession(&cfg.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.Login(); err != nil {
+		return nil, fmt.Errorf("could not login to judge API: %w", err)
+	}
+
+	return &HTTPFetcher{session: session, langSet: cfg.LangSet}, nil
+}
+
+func (f *HTTPFetcher) GetJobs(maxJobs int) ([]int, error) {
+	// The web session may expire between polls, so re-login when needed.
+	if err := f.session.EnsureLogin(); err != nil {
+		return nil, fmt.Errorf("error logging in to judge API: %w", err)
+	}
+
+	f.mu.Lock()
+	params := url.Values{
+		"getpending":  {"1"},
+		"oj_lang_set": {f.langSet},
+		"max_running": {strconv.Itoa(maxJobs)},
+	}
+	f.mu.Unlock()
+	body, err := f.session.Call(params)
+	if err != nil {
+		return nil, fmt.Errorf("error getting pending jobs: %w", err)
+	}
+
+	var jobs []int
+	for _, field := range strings.Fields(body) {
+		solutionID, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected pending job %q: %w", field, err)
+		}
+		jobs = append(jobs, solutionID)
+	}
+	return jobs, nil
+}
+
+// Reconfigure asks for the languages of cfg from now on.
+func (f *HTTPFetcher) Reconfigure(cfg *DaemonConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.langSet = cfg.LangSet
+}
+
+func (f *HTTPFetcher) CheckOut(solutionID int, result int) (bool, error) {
+	body, err := f.session.Call(url.Values{
+		"checkout": {"1"},
+		"sid":      {strconv.Itoa(solutionID)},
+		"result":   {strconv.Itoa(result)},
+	})
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(body) == "1", nil
+}
+
+// Requeue resets the solution to OJ_WT1 through the judge API. Unlike the
+// MySQL Requeue it cannot check that no verdict was written yet, so it must
+// only be called for jobs known to have none: killed ones, or crashed ones
+// whose client exited without a final report.
+func (f *HTTPFetcher) Requeue(solutionID int) error {
+	_, err := f.session.Call(url.Values{
+		"update_solution": {"1"},
+		"sid":             {strconv.Itoa(solutionID)},
+		"result":          {strconv.Itoa(OJ_WT1)},
+		"time":            {"0"},
+		"memory":          {"0"},
+		"sim":             {"0"},
+		"simid":           {"0"},
+		"pass_rate":       {"0"},
+	})
+	return err
+}
+
+func (f *HTTPFetcher) Close() error {
+	return f.session.Close()
+}
@@ -0,0 +1,183 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+func newRedisTestFetcher(t *testing.T, mr *miniredis.Miniredis, judger string) *RedisFetcher {
+	t.Helper()
+	port, _ := strconv.Atoi(mr.Port())
+	cfg := &DaemonConfig{
+		JudgeConfig:  &config.JudgeConfig{Judger: judger},
+		RedisServer:  mr.Host(),
+		RedisPort:    port,
+		RedisQName:   "hustoj",
+		RedisRequeue: 60,
+	}
+	f, err := NewRedisFetcher(cfg)
+	if err != nil {
+		t.Fatalf("NewRedisFetcher: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// take checks out every job GetJobs returns.
+func take(t *testing.T, f *RedisFetcher, maxJobs int) []int {
+	t.Helper()
+	jobs, err := f.GetJobs(maxJobs)
+	if err != nil {
+		t.Fatalf("GetJobs: %v", err)
+	}
+	for _, sid := range jobs {
+		if ok, err := f.CheckOut(sid, OJ_CI); err != nil || !ok {
+			t.Fatalf("CheckOut(%d) = %v, %v; want true", sid, ok, err)
+		}
+	}
+	return jobs
+}
+
+func TestRedisFetcherMovesJobsToProcessing(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// The web frontend LPUSHes new submissions.
+	mr.Lpush("hustoj", "1")
+	mr.Lpush("hustoj", "2")
+	mr.Lpush("hustoj", "3")
+
+	f := newRedisTestFetcher(t, mr, "j1")
+
+	jobs, err := f.GetJobs(2)
+	if err != nil {
+		t.Fatalf("GetJobs: %v", err)
+	}
+	if fmt.Sprint(jobs) != "[1 2]" {
+		t.Errorf("GetJobs = %v; want [1 2]", jobs)
+	}
+	if queued, _ := mr.List("hustoj"); len(queued) != 3 {
+		t.Errorf("queue = %v; GetJobs must not take jobs", queued)
+	}
+
+	take(t, f, 2)
+	inflight, _ := mr.List("hustoj:processing:j1")
+	if len(inflight) != 2 {
+		t.Errorf("processing list = %v; want 2 entries", inflight)
+	}
+	if queued, _ := mr.List("hustoj"); fmt.Sprint(queued) != "[3]" {
+		t.Errorf("queue = %v; want [3]", queued)
+	}
+
+	if err := f.Ack(1); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	inflight, _ = mr.List("hustoj:processing:j1")
+	if fmt.Sprint(inflight) != "[2]" {
+		t.Errorf("processing list after ack = %v; want [2]", inflight)
+	}
+	if mr.HGet("hustoj:started", "1") != "" {
+		t.Error("start time of acknowledged job was not removed")
+	}
+}
+
+func TestRedisFetcherRequeuesOrphansOnStartup(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.Lpush("hustoj", "10")
+	mr.Lpush("hustoj", "11")
+
+	crashed := newRedisTestFetcher(t, mr, "j1")
+	take(t, crashed, 2)
+
+	// A restarted daemon with the same identity takes the jobs back.
+	restarted := newRedisTestFetcher(t, mr, "j1")
+	if n, err := restarted.Recover(); err != nil || n != 2 {
+		t.Fatalf("Recover = %d, %v; want 2", n, err)
+	}
+	jobs, err := restarted.GetJobs(5)
+	if err != nil {
+		t.Fatalf("GetJobs after restart: %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Errorf("GetJobs after restart = %v; want both orphaned jobs", jobs)
+	}
+}
+
+func TestRedisFetcherReapsStaleJobs(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.Lpush("hustoj", "20")
+	mr.Lpush("hustoj", "21")
+
+	dead := newRedisTestFetcher(t, mr, "dead")
+	take(t, dead, 2)
+	// Job 20 was taken long ago, job 21 just now.
+	mr.HSet("hustoj:started", "20", strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+
+	alive := newRedisTestFetcher(t, mr, "alive")
+	n, err := alive.requeueStale(context.Background())
+	if err != nil {
+		t.Fatalf("requeueStale: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("requeued %d jobs; want 1", n)
+	}
+
+	jobs, err := alive.GetJobs(5)
+	if err != nil {
+		t.Fatalf("GetJobs: %v", err)
+	}
+	if fmt.Sprint(jobs) != "[20]" {
+		t.Errorf("GetJobs = %v; want [20]", jobs)
+	}
+	inflight, _ := mr.List("hustoj:processing:dead")
+	if fmt.Sprint(inflight) != "[21]" {
+		t.Errorf("dead judger still holds %v; want [21]", inflight)
+	}
+}
+
+func TestRedisFetcherRenewsJobsBeingJudged(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.Lpush("hustoj", "30")
+	mr.Lpush("hustoj", "31")
+
+	slow := newRedisTestFetcher(t, mr, "slow")
+	take(t, slow, 2)
+	if err := slow.Requeue(31); err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	// Job 30 has been judged for longer than RedisRequeue.
+	mr.HSet("hustoj:started", "30", strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	if err := slow.renew(context.Background()); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if mr.HGet("hustoj:started", "31") != "" {
+		t.Error("renew brought back the time taken of a requeued job")
+	}
+
+	other := newRedisTestFetcher(t, mr, "other")
+	if n, err := other.requeueStale(context.Background()); err != nil || n != 0 {
+		t.Errorf("requeueStale = %d, %v; a job still being judged was reaped", n, err)
+	}
+	if inflight, _ := mr.List("hustoj:processing:slow"); fmt.Sprint(inflight) != "[30]" {
+		t.Errorf("slow judger holds %v; want [30]", inflight)
+	}
+}
+
+func TestRedisFetcherCheckOutOnce(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.Lpush("hustoj", "30")
+
+	a := newRedisTestFetcher(t, mr, "a")
+	b := newRedisTestFetcher(t, mr, "b")
+
+	if ok, err := a.CheckOut(30, OJ_CI); err != nil || !ok {
+		t.Fatalf("first CheckOut = %v, %v; want true", ok, err)
+	}
+	if ok, err := b.CheckOut(30, OJ_CI); err != nil || ok {
+		t.Errorf("second CheckOut = %v, %v; want false", ok, err)
+	}
+}
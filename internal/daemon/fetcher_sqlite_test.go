@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+func newTestSQLiteFetcher(t *testing.T, judger string) *SQLiteFetcher {
+	t.Helper()
+	cfg := &DaemonConfig{
+		JudgeConfig: &config.JudgeConfig{
+			Judger:   judger,
+			Database: config.DatabaseConfig{Driver: "sqlite", Path: filepath.Join(t.TempDir(), "hustoj.db")},
+		},
+		MaxRunning: 2,
+		LangSet:    "0,1",
+	}
+	f, err := NewSQLiteFetcher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	for _, q := range []string{
+		"INSERT INTO contest (contest_id, start_time, end_time) VALUES (7, datetime('now', '-1 hour'), datetime('now', '+1 hour'))",
+		"INSERT INTO solution (solution_id, user_id, language, result) VALUES (1, 'a', 0, 0), (2, 'b', 1, 1), (3, 'c', 2, 0), (4, 'd', 0, 4)",
+		"INSERT INTO solution (solution_id, user_id, language, result, contest_id) VALUES (5, 'e', 0, 0, 7)",
+	} {
+		if _, err := f.db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func TestSQLiteFetcher(t *testing.T) {
+	f := newTestSQLiteFetcher(t, "j1")
+
+	jobs, err := f.GetJobs(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 5, 2}; !slices.Equal(jobs, want) {
+		t.Errorf("GetJobs = %v; want %v", jobs, want)
+	}
+	if n, err := f.QueueLength(); err != nil || n != 3 {
+		t.Errorf("QueueLength = %d, %v; want 3", n, err)
+	}
+
+	described, err := f.Describe(jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !described[1].InContest || described[0].InContest || !described[2].Rejudge {
+		t.Errorf("Describe = %+v", described)
+	}
+
+	if ok, err := f.CheckOut(1, OJ_CI); !ok || err != nil {
+		t.Fatalf("first CheckOut = %v, %v; want true", ok, err)
+	}
+	if ok, err := f.CheckOut(1, OJ_CI); ok || err != nil {
+		t.Errorf("second CheckOut = %v, %v; want false", ok, err)
+	}
+	var judger string
+	f.db.QueryRow("SELECT judger FROM solution WHERE solution_id=1").Scan(&judger)
+	if judger != "j1" {
+		t.Errorf("judger = %q; want j1", judger)
+	}
+
+	if err := f.Requeue(1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := f.CheckOut(1, OJ_CI); !ok {
+		t.Error("requeued solution could not be checked out again")
+	}
+	if ok, _ := f.CheckOut(2, OJ_CI); !ok {
+		t.Error("could not check out solution 2")
+	}
+
+	// A restart finds both solutions still checked out by this judger.
+	if n, err := f.Recover(); err != nil || n != 2 {
+		t.Errorf("Recover = %d, %v; want 2", n, err)
+	}
+}
+
+func TestSQLiteFetcherRecoverOtherJudger(t *testing.T) {
+	f := newTestSQLiteFetcher(t, "j1")
+	if _, err := f.db.Exec("UPDATE solution SET result=2, judger='j2', judgetime=datetime('now', '-10 minutes') WHERE solution_id=1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := f.Recover(); err != nil || n != 0 {
+		t.Errorf("Recover without OJ_RECOVER_AGE = %d, %v; want 0", n, err)
+	}
+	f.recoverAge = 300
+	if n, err := f.Recover(); err != nil || n != 1 {
+		t.Errorf("Recover of abandoned solution = %d, %v; want 1", n, err)
+	}
+}
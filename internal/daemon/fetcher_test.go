@@ -0,0 +1,155 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+// fakeJudgeAPI stands in for the HUSTOJ login page and admin judge API.
+type fakeJudgeAPI struct {
+	mu       sync.Mutex
+	session  string
+	logins   int
+	pending  []int
+	langSet  string
+	max      string // max_running of the last getpending
+	checked  map[int]int
+	checkout map[int]bool
+	garbage  bool
+}
+
+func (f *fakeJudgeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/login.php" {
+		if r.PostForm.Get("user_id") == "judge" && r.PostForm.Get("password") == "secret" {
+			f.logins++
+			f.session = fmt.Sprintf("s%d", f.logins)
+			http.SetCookie(w, &http.Cookie{Name: "PHPSESSID", Value: f.session, Path: "/"})
+		}
+		return
+	}
+
+	c, err := r.Cookie("PHPSESSID")
+	if err != nil || f.session == "" || c.Value != f.session {
+		return // not logged in: the real API prints nothing useful
+	}
+
+	switch {
+	case r.PostForm.Has("checklogin"):
+		fmt.Fprint(w, "1")
+	case r.PostForm.Has("getpending"):
+		f.langSet = r.PostForm.Get("oj_lang_set")
+		f.max = r.PostForm.Get("max_running")
+		if f.garbage {
+			fmt.Fprint(w, "<html>error</html>")
+			return
+		}
+		for _, id := range f.pending {
+			fmt.Fprintf(w, "%d\n", id)
+		}
+	case r.PostForm.Has("checkout"):
+		var sid, result int
+		fmt.Sscan(r.PostForm.Get("sid"), &sid)
+		fmt.Sscan(r.PostForm.Get("result"), &result)
+		if f.checkout[sid] {
+			fmt.Fprint(w, "0")
+			return
+		}
+		f.checkout[sid] = true
+		f.checked[sid] = result
+		fmt.Fprint(w, "1")
+	}
+}
+
+func newHTTPTestFetcher(t *testing.T, api *fakeJudgeAPI) *HTTPFetcher {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	cfg := &DaemonConfig{
+		JudgeConfig: &config.JudgeConfig{HTTP: config.HTTPConfig{
+			Enable:    true,
+			BaseURL:   srv.URL,
+			APIPath:   "/admin/problem_judge.php",
+			LoginPath: "/login.php",
+			Username:  "judge",
+			Password:  "secret",
+		}},
+		MaxRunning: 2,
+		LangSet:    "0,1",
+	}
+	f, err := NewHTTPFetcher(cfg)
+	if err != nil {
+		t.Fatalf("NewHTTPFetcher: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestHTTPFetcherGetJobsAndCheckOut(t *testing.T) {
+	api := &fakeJudgeAPI{pending: []int{7, 9}, checked: map[int]int{}, checkout: map[int]bool{}}
+	f := newHTTPTestFetcher(t, api)
+
+	jobs, err := f.GetJobs(3)
+	if err != nil {
+		t.Fatalf("GetJobs: %v", err)
+	}
+	if fmt.Sprint(jobs) != "[7 9]" {
+		t.Errorf("GetJobs = %v; want [7 9]", jobs)
+	}
+	if api.langSet != "0,1" || api.max != "3" {
+		t.Errorf("oj_lang_set = %q, max_running = %q; want 0,1 and the 3 jobs asked for", api.langSet, api.max)
+	}
+
+	ok, err := f.CheckOut(7, OJ_CI)
+	if err != nil || !ok {
+		t.Fatalf("CheckOut(7) = %v, %v; want true", ok, err)
+	}
+	if api.checked[7] != OJ_CI {
+		t.Errorf("checked out with result %d; want %d", api.checked[7], OJ_CI)
+	}
+
+	ok, err = f.CheckOut(7, OJ_CI)
+	if err != nil || ok {
+		t.Errorf("second CheckOut(7) = %v, %v; want false", ok, err)
+	}
+}
+
+func TestHTTPFetcherRelogin(t *testing.T) {
+	api := &fakeJudgeAPI{pending: []int{3}, checked: map[int]int{}, checkout: map[int]bool{}}
+	f := newHTTPTestFetcher(t, api)
+
+	// Drop the server-side session to simulate expiry.
+	api.mu.Lock()
+	api.session = "expired"
+	api.mu.Unlock()
+
+	jobs, err := f.GetJobs(1)
+	if err != nil {
+		t.Fatalf("GetJobs after expiry: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0] != 3 {
+		t.Errorf("GetJobs = %v; want [3]", jobs)
+	}
+	if api.logins != 2 {
+		t.Errorf("logins = %d; want 2", api.logins)
+	}
+}
+
+func TestHTTPFetcherBadResponse(t *testing.T) {
+	api := &fakeJudgeAPI{garbage: true, checked: map[int]int{}, checkout: map[int]bool{}}
+	f := newHTTPTestFetcher(t, api)
+
+	if _, err := f.GetJobs(1); err == nil || !strings.Contains(err.Error(), "unexpected pending job") {
+		t.Errorf("GetJobs err = %v; want parse error", err)
+	}
+}
@@ -0,0 +1,75 @@
+package daemon
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// health tracks whether the daemon can reach its job queue. During an
+// outage the worker backs off and the daemon reports itself unhealthy
+// until a fetch succeeds again.
+type health struct {
+	mu       sync.Mutex
+	lastErr  error
+	since    time.Time // Start of the current outage
+	failures int       // Consecutive failed fetches
+}
+
+// fail records a failed fetch and returns the number of consecutive failures.
+func (h *health) fail(err error) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failures == 0 {
+		h.since = time.Now()
+	}
+	h.failures++
+	h.lastErr = err
+	return h.failures
+}
+
+// ok records a successful fetch and returns how long the outage it ends
+// lasted, or zero if there was none.
+func (h *health) ok() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failures == 0 {
+		return 0
+	}
+	outage := time.Since(h.since)
+	h.failures, h.lastErr = 0, nil
+	return outage
+}
+
+// status reports whether the job queue is reachable and, if not, since
+// when and why.
+func (h *health) status() (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failures == 0 {
+		return true, "ok"
+	}
+	return false, fmt.Sprintf("job queue unreachable for %s (%d failed fetches): %v",
+		time.Since(h.since).Round(time.Second), h.failures, h.lastErr)
+}
+
+// serveHealth answers health checks: 200 while the job queues of all
+// workers are reachable, 503 during an outage of any.
+func serveHealth(rw http.ResponseWriter, workers []*Worker) {
+	healthy := true
+	var details []string
+	for _, w := range workers {
+		ok, detail := w.health.status()
+		healthy = healthy && ok
+		if w.site != "" {
+			detail = w.site + ": " + detail
+		}
+		details = append(details, detail)
+	}
+	if !healthy {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprintln(rw, strings.Join(details, "\n"))
+}
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// downFetcher fails every fetch while down is set.
+type downFetcher struct {
+	stubFetcher
+	down bool
+}
+
+func (f *downFetcher) GetJobs(maxJobs int) ([]int, error) {
+	if f.down {
+		f.fetches++
+		return nil, errors.New("dial tcp 127.0.0.1:3306: connect: connection refused")
+	}
+	return f.stubFetcher.GetJobs(maxJobs)
+}
+
+func TestWorkerBacksOffWhileQueueIsDown(t *testing.T) {
+	f := &downFetcher{down: true}
+	w := NewWorker(&DaemonConfig{MaxRunning: 1}, f)
+
+	w.work()
+	w.queue.stale = true // A poll or wake-up during the backoff
+	w.work()
+	if f.fetches != 1 {
+		t.Errorf("fetches = %d; want 1 during the backoff", f.fetches)
+	}
+	if healthy, detail := w.health.status(); healthy || !strings.Contains(detail, "connection refused") {
+		t.Errorf("status = %v, %q; want unhealthy with the error", healthy, detail)
+	}
+	if got := testutil.ToFloat64(w.metrics.backendUp); got != 0 {
+		t.Errorf("backend up = %v; want 0", got)
+	}
+
+	rec := httptest.NewRecorder()
+	serveHealth(rec, []*Worker{w})
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz = %d; want 503", rec.Code)
+	}
+
+	// The server is back once the backoff has passed.
+	f.down = false
+	w.retryAt = time.Now()
+	w.refill()
+	if healthy, _ := w.health.status(); !healthy {
+		t.Error("still unhealthy after a successful fetch")
+	}
+	if w.backoff.Attempts() != 0 || !w.retryAt.IsZero() {
+		t.Error("backoff was not reset after a successful fetch")
+	}
+}
@@ -0,0 +1,111 @@
+package daemon
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// leaseKeeper coordinates judgers sharing one MySQL database without
+// OJ_TOTAL/OJ_MOD. A claimed solution carries the judger and a lease in its
+// judgetime column, which is renewed while it is being judged. When a
+// judger dies its leases run out and any other judger can claim the
+// solutions again.
+type leaseKeeper struct {
+	db     *sql.DB
+	judger string
+	ttl    time.Duration
+	mu     sync.Mutex
+	held   map[int]bool // Solutions whose lease this judger renews
+	done   chan struct{}
+}
+
+func newLeaseKeeper(db *sql.DB, judger string, ttl time.Duration) *leaseKeeper {
+	return &leaseKeeper{
+		db:     db,
+		judger: judger,
+		ttl:    ttl,
+		held:   make(map[int]bool),
+		done:   make(chan struct{}),
+	}
+}
+
+// claim checks out a pending solution, or one whose lease has expired.
+func (l *leaseKeeper) claim(solutionID, result int) (bool, error) {
+	query := `UPDATE solution SET result=?, time=0, memory=0, judgetime=NOW(), judger=?
+              WHERE solution_id=? AND (result<2 OR (result IN (2,3) AND judgetime < NOW() - INTERVAL ? SECOND)) LIMIT 1`
+	res, err := l.db.Exec(query, result, l.judger, solutionID, int(l.ttl.Seconds()))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	l.hold(solutionID)
+	return true, nil
+}
+
+func (l *leaseKeeper) hold(solutionID int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.held[solutionID] = true
+}
+
+func (l *leaseKeeper) release(solutionID int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.held, solutionID)
+}
+
+func (l *leaseKeeper) heldIDs() []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ids := make([]int, 0, len(l.held))
+	for id := range l.held {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// run renews the held leases three times per lease period until stop.
+func (l *leaseKeeper) run() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if err := l.renew(); err != nil {
+				slog.Warn("Could not renew judge leases", "err", err)
+			}
+		}
+	}
+}
+
+func (l *leaseKeeper) renew() error {
+	ids := l.heldIDs()
+	if len(ids) == 0 {
+		return nil
+	}
+
+	args := []any{l.judger}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := fmt.Sprintf("UPDATE solution SET judgetime=NOW() WHERE judger=? AND result IN (2,3) AND solution_id IN (%s)",
+		strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","))
+	_, err := l.db.Exec(query, args...)
+	return err
+}
+
+func (l *leaseKeeper) stop() {
+	close(l.done)
+}
@@ -0,0 +1,124 @@
+package daemon
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// The statements leaseKeeper must send, compared exactly but for
+// whitespace. A pending solution is claimed, as is one whose judger let its
+// lease of ? seconds run out.
+const (
+	claimQuery = "UPDATE solution SET result=?, time=0, memory=0, judgetime=NOW(), judger=? " +
+		"WHERE solution_id=? AND (result<2 OR (result IN (2,3) AND judgetime < NOW() - INTERVAL ? SECOND)) LIMIT 1"
+	renewQuery = "UPDATE solution SET judgetime=NOW() WHERE judger=? AND result IN (2,3) AND solution_id IN "
+)
+
+func newMockLeaseKeeper(t *testing.T, judger string, ttl time.Duration) (*leaseKeeper, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return newLeaseKeeper(db, judger, ttl), mock
+}
+
+func TestPendingConditionWithLeases(t *testing.T) {
+	cfg := &DaemonConfig{LangSet: "0,1", TotalJudges: 3, JudgeMod: 1}
+	if got := pendingCondition(cfg); !strings.Contains(got, "MOD(solution_id,3)=1") {
+		t.Errorf("pendingCondition without leases = %q; want OJ_MOD sharding", got)
+	}
+
+	cfg.LeaseTime = 90
+	got := pendingCondition(cfg)
+	if strings.Contains(got, "MOD(") {
+		t.Errorf("pendingCondition with leases = %q; must not shard", got)
+	}
+	if !strings.Contains(got, "INTERVAL 90 SECOND") {
+		t.Errorf("pendingCondition with leases = %q; want expired leases included", got)
+	}
+}
+
+func TestLeaseKeeperTracksHeldSolutions(t *testing.T) {
+	l := newLeaseKeeper(nil, "j1", time.Minute)
+	l.hold(7)
+	l.hold(3)
+	l.hold(7)
+	l.release(5)
+
+	if got := fmt.Sprint(l.heldIDs()); got != "[3 7]" {
+		t.Errorf("held = %v; want [3 7]", got)
+	}
+	l.release(3)
+	if got := fmt.Sprint(l.heldIDs()); got != "[7]" {
+		t.Errorf("held after release = %v; want [7]", got)
+	}
+}
+
+func TestLeaseClaim(t *testing.T) {
+	l, mock := newMockLeaseKeeper(t, "j1", 90*time.Second)
+	mock.ExpectExec(claimQuery).WithArgs(OJ_CI, "j1", 7, 90).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(claimQuery).WithArgs(OJ_CI, "j1", 8, 90).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if ok, err := l.claim(7, OJ_CI); !ok || err != nil {
+		t.Fatalf("claim(7) = %v, %v; want a pending solution claimed", ok, err)
+	}
+	// Solution 8 is judged elsewhere under a lease that has not run out.
+	if ok, err := l.claim(8, OJ_CI); ok || err != nil {
+		t.Fatalf("claim(8) = %v, %v; want a live lease respected", ok, err)
+	}
+	if got := fmt.Sprint(l.heldIDs()); got != "[7]" {
+		t.Errorf("held = %v; want [7]", got)
+	}
+}
+
+func TestLeaseClaimAfterExpiry(t *testing.T) {
+	// j1 died while judging 7. j2 takes it over only through the expiry
+	// predicate of claimQuery, bound to its lease time in whole seconds.
+	l, mock := newMockLeaseKeeper(t, "j2", 30*time.Second)
+	mock.ExpectExec(claimQuery).WithArgs(OJ_CI, "j2", 7, 30).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if ok, err := l.claim(7, OJ_CI); !ok || err != nil {
+		t.Fatalf("claim(7) = %v, %v; want the expired lease taken over", ok, err)
+	}
+	if got := fmt.Sprint(l.heldIDs()); got != "[7]" {
+		t.Errorf("held = %v; want [7]", got)
+	}
+}
+
+func TestLeaseRenew(t *testing.T) {
+	l, mock := newMockLeaseKeeper(t, "j1", 30*time.Millisecond)
+	if err := l.renew(); err != nil {
+		t.Fatalf("renew without leases: %v", err) // Must not touch the database
+	}
+
+	l.hold(7)
+	l.hold(3)
+	mock.ExpectExec(renewQuery+"(?,?)").WithArgs("j1", 3, 7).WillReturnResult(sqlmock.NewResult(0, 2))
+	if err := l.renew(); err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+
+	// run renews the remaining lease well before it expires.
+	l.release(3)
+	mock.ExpectExec(renewQuery+"(?)").WithArgs("j1", 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	go l.run()
+	defer l.stop()
+	deadline := time.Now().Add(time.Second)
+	for mock.ExpectationsWereMet() != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("lease not renewed by run")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
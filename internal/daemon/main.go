@@ -6,7 +6,10 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"time"
 
+	"github.com/sempr/hustoj-go/pkg/backoff"
+	"github.com/sempr/hustoj-go/pkg/config"
 	"github.com/sempr/hustoj-go/pkg/models"
 	"github.com/sevlyar/go-daemon"
 	"golang.org/x/sys/unix"
@@ -23,20 +26,26 @@ func Main(ccfg *models.DaemonArgs) {
 	}
 
 	// Load configuration
-	cfg, err := LoadDaemonConfig("etc/judge.conf")
+	cfg, err := loadConfig()
 	if err != nil {
 		slog.Error("FATAL: Error loading judge.conf", "err", err)
 		os.Exit(1)
 	}
-
-	cfg.OJHome = daemonArgs.OJHome
-	cfg.Debug = daemonArgs.Debug
-	cfg.Once = daemonArgs.Once
+	if err := config.ValidateInstance(cfg.Instance); err != nil {
+		slog.Error("FATAL: Bad instance name", "err", err)
+		os.Exit(1)
+	}
+	sites, err := loadSites(cfg)
+	if err != nil {
+		slog.Error("FATAL: Error loading the sites of judge.conf", "err", err)
+		os.Exit(1)
+	}
+	suffix := config.InstanceSuffix(cfg.Instance)
+	pidFilePath := filepath.Join(cfg.ConfHome, "etc", "judge"+suffix+".pid")
 
 	// Set up daemonization if not in debug mode
 	if !cfg.Debug {
-		pidFilePath := filepath.Join(cfg.OJHome, "etc", "judge.pid")
-		logFilePath := filepath.Join(cfg.OJHome, "log", "judged-go.log")
+		logFilePath := filepath.Join(cfg.ConfHome, "log", "judged-go"+suffix+".log")
 
 		cntxt := &daemon.Context{
 			PidFileName: pidFilePath,
@@ -58,24 +67,15 @@ func Main(ccfg *models.DaemonArgs) {
 		defer cntxt.Release()
 	}
 
-	slog.Info("judged-go started")
+	slog.Info("judged-go started", "instance", cfg.Instance)
 
-	// Lock PID file to ensure a single instance
-	lockFile := filepath.Join(cfg.OJHome, "etc", "judge.pid")
-	if err := Lock(lockFile); err != nil {
+	// Lock PID file to ensure a single daemon per instance
+	if err := Lock(pidFilePath); err != nil {
 		slog.Error("FATAL: Daemon is already running", "err", err)
 		os.Exit(1)
 	}
 	defer Unlock()
 
-	// Create the job fetcher
-	fetcher, err := NewFetcher(cfg)
-	if err != nil {
-		slog.Error("FATAL: Could not create fetcher", "err", err)
-		os.Exit(1)
-	}
-	defer fetcher.Close()
-
 	// Channel to stop the program gracefully
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, unix.SIGINT, unix.SIGTERM, unix.SIGQUIT)
@@ -87,9 +87,96 @@ func Main(ccfg *models.DaemonArgs) {
 		cancel()
 	}()
 
-	// Create and run the worker
-	worker := NewWorker(cfg, fetcher)
-	worker.Run(ctx)
+	confineHousekeeping(cfg)
+
+	// Create a worker per site. Several sites share one pool of slots.
+	var pool *slotPool
+	if sites[0].Site != "" {
+		pool = newSlotPool(cfg.MaxRunning)
+	}
+	var workers []*Worker
+	for _, site := range sites {
+		worker, err := newSiteWorker(ctx, site)
+		if err != nil {
+			slog.Error("FATAL: Could not start site", "site", site.Site, "err", err)
+			os.Exit(1)
+		}
+		defer worker.close()
+		if pool != nil {
+			worker.joinPool(pool)
+			slog.Info("Serving site", "site", site.Site, "oj_home", site.OJHome, "weight", site.SiteWeight)
+		}
+		workers = append(workers, worker)
+	}
+	if cfg.InternalClient {
+		slog.Info("Judging submissions in-process")
+	}
+	if cfg.MetricsAddr != "" {
+		go serveMetrics(cfg.MetricsAddr, workers)
+	}
+	defer startServices(ctx, cfg, workers)()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, unix.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for range hup {
+			slog.Info("SIGHUP received, reloading judge.conf")
+			next, err := loadConfig()
+			if err != nil {
+				slog.Error("Could not reload judge.conf, keeping the old configuration", "err", err)
+				continue
+			}
+			nextSites, err := loadSites(next)
+			if err != nil {
+				slog.Error("Could not reload the sites of judge.conf, keeping the old configuration", "err", err)
+				continue
+			}
+			reloadSites(workers, pool, next, nextSites)
+		}
+	}()
+	runWorkers(ctx, workers)
 
 	slog.Info("judged-go stopped.")
 }
+
+// loadConfig reads the top of the judge.conf of the instance and applies
+// the command line flags.
+func loadConfig() (*DaemonConfig, error) {
+	cfg, err := LoadDaemonConfig(".", daemonArgs.Instance, "")
+	if err != nil {
+		return nil, err
+	}
+	applyArgs(cfg)
+	return cfg, nil
+}
+
+// applyArgs applies the command line flags to a configuration read from the
+// OJ_HOME the daemon changed to, including an OJ_HOME relative to it.
+func applyArgs(cfg *DaemonConfig) {
+	cfg.ConfHome = daemonArgs.OJHome
+	if !filepath.IsAbs(cfg.OJHome) {
+		cfg.OJHome = filepath.Join(daemonArgs.OJHome, cfg.OJHome)
+	}
+	cfg.Debug = daemonArgs.Debug
+	cfg.Once = daemonArgs.Once
+}
+
+// retryStartup calls connect until it succeeds, backing off between
+// attempts, so a daemon started before its database comes up waits for it.
+// It only gives up when ctx is cancelled.
+func retryStartup(ctx context.Context, what string, connect func() error) error {
+	b := backoff.Backoff{Base: fetchBackoffBase, Max: fetchBackoffMax}
+	for {
+		err := connect()
+		if err == nil {
+			return nil
+		}
+		delay := b.Next()
+		slog.Warn("Could not connect, retrying", "what", what, "err", err, "retry_in", delay.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
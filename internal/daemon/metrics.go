@@ -0,0 +1,150 @@
+package daemon
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sempr/hustoj-go/pkg/constants"
+	"github.com/sempr/hustoj-go/pkg/models"
+)
+
+// QueueLengther is implemented by fetchers that can report how many
+// submissions are waiting to be judged.
+type QueueLengther interface {
+	QueueLength() (int, error)
+}
+
+// judgeBuckets covers judgements from a few hundred milliseconds up to the
+// multi-minute OI problems with many data files.
+var judgeBuckets = []float64{0.25, 0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300, 600}
+
+// metrics holds the Prometheus collectors of the daemon.
+type metrics struct {
+	registry      *prometheus.Registry
+	runningSlots  prometheus.Gauge
+	maxRunning    prometheus.Gauge
+	judgements    *prometheus.CounterVec
+	queueWait     prometheus.Histogram
+	compileTime   prometheus.Histogram
+	judgeTime     prometheus.Histogram
+	systemErrors  prometheus.Counter
+	fetcherErrors prometheus.Counter
+	backendUp     prometheus.Gauge
+}
+
+func newMetrics(cfg *DaemonConfig, fetcher JobFetcher) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		runningSlots: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "hustoj_running_slots",
+			Help: "Number of client slots currently judging.",
+		}),
+		maxRunning: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "hustoj_max_running_slots",
+			Help: "Configured number of client slots (OJ_RUNNING).",
+		}),
+		judgements: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "hustoj_judgements_total",
+			Help: "Finished judgements by verdict and language id.",
+		}, []string{"verdict", "language"}),
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "hustoj_queue_wait_seconds",
+			Help:    "Time from submission until judging started.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 14),
+		}),
+		compileTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "hustoj_compile_seconds",
+			Help:    "Time spent compiling submissions.",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 20},
+		}),
+		judgeTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "hustoj_judge_seconds",
+			Help:    "Wall time a submission occupied a client slot.",
+			Buckets: judgeBuckets,
+		}),
+		systemErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "hustoj_sandbox_system_errors_total",
+			Help: "Sandbox runs that ended in a system error.",
+		}),
+		fetcherErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "hustoj_fetcher_errors_total",
+			Help: "Errors while fetching or checking out jobs.",
+		}),
+		backendUp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "hustoj_backend_up",
+			Help: "1 if the last fetch from the job queue succeeded, 0 while backing off.",
+		}),
+	}
+
+	// The collectors of each site carry its name, so the sites of one
+	// daemon can be served together.
+	var reg prometheus.Registerer = m.registry
+	if cfg.JudgeConfig != nil && cfg.Site != "" {
+		reg = prometheus.WrapRegistererWith(prometheus.Labels{"site": cfg.Site}, reg)
+	}
+	reg.MustRegister(m.runningSlots, m.maxRunning, m.judgements,
+		m.queueWait, m.compileTime, m.judgeTime, m.systemErrors, m.fetcherErrors, m.backendUp)
+	m.maxRunning.Set(float64(cfg.MaxRunning))
+	m.backendUp.Set(1)
+
+	if ql, ok := fetcher.(QueueLengther); ok {
+		reg.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "hustoj_queue_depth",
+			Help: "Submissions waiting to be judged, as seen by the fetcher.",
+		}, func() float64 {
+			n, err := ql.QueueLength()
+			if err != nil {
+				slog.Warn("Could not read queue depth", "err", err)
+				return -1
+			}
+			return float64(n)
+		}))
+	}
+
+	return m
+}
+
+// observe records a finished judgement.
+func (m *metrics) observe(report *models.JudgeReport, elapsed time.Duration) {
+	m.judgeTime.Observe(elapsed.Seconds())
+	if report == nil {
+		return
+	}
+
+	verdict := "none"
+	if report.Result != 0 {
+		verdict = constants.GetOJResultName(report.Result)
+	}
+	m.judgements.WithLabelValues(verdict, strconv.Itoa(report.Language)).Inc()
+	if report.QueueWaitMs > 0 {
+		m.queueWait.Observe(float64(report.QueueWaitMs) / 1000)
+	}
+	if report.CompileMs > 0 {
+		m.compileTime.Observe(float64(report.CompileMs) / 1000)
+	}
+	m.systemErrors.Add(float64(report.SystemErrors))
+}
+
+// serveMetrics exposes /metrics and /healthz of the workers on addr until
+// the server fails.
+func serveMetrics(addr string, workers []*Worker) {
+	var gatherers prometheus.Gatherers
+	for _, w := range workers {
+		gatherers = append(gatherers, w.metrics.registry)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, r *http.Request) {
+		serveHealth(rw, workers)
+	})
+
+	slog.Info("Serving metrics", "addr", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Metrics server stopped", "err", err)
+	}
+}
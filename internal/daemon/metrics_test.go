@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sempr/hustoj-go/pkg/constants"
+	"github.com/sempr/hustoj-go/pkg/models"
+)
+
+type queueStub struct{ stubFetcher }
+
+func (q *queueStub) QueueLength() (int, error) { return 17, nil }
+
+func TestMetricsObserve(t *testing.T) {
+	m := newMetrics(&DaemonConfig{MaxRunning: 4}, &stubFetcher{})
+
+	m.observe(&models.JudgeReport{Language: 1, Result: constants.OJ_AC, QueueWaitMs: 1500, CompileMs: 800}, 3*time.Second)
+	m.observe(&models.JudgeReport{Language: 1, Result: constants.OJ_SE, SystemErrors: 2}, time.Second)
+	m.observe(nil, time.Second)
+
+	if got := testutil.ToFloat64(m.judgements.WithLabelValues("AC", "1")); got != 1 {
+		t.Errorf("AC judgements = %v; want 1", got)
+	}
+	if got := testutil.ToFloat64(m.systemErrors); got != 2 {
+		t.Errorf("system errors = %v; want 2", got)
+	}
+	if got := testutil.ToFloat64(m.maxRunning); got != 4 {
+		t.Errorf("max running = %v; want 4", got)
+	}
+
+	if n := testutil.CollectAndCount(m.judgeTime); n != 1 {
+		t.Errorf("judge time series = %d; want 1", n)
+	}
+}
+
+func TestMetricsQueueDepth(t *testing.T) {
+	m := newMetrics(&DaemonConfig{MaxRunning: 1}, &queueStub{})
+
+	n, err := testutil.GatherAndCount(m.registry, "hustoj_queue_depth")
+	if err != nil || n != 1 {
+		t.Fatalf("queue depth series = %d, %v; want 1", n, err)
+	}
+	expected := `
+# HELP hustoj_queue_depth Submissions waiting to be judged, as seen by the fetcher.
+# TYPE hustoj_queue_depth gauge
+hustoj_queue_depth 17
+`
+	if err := testutil.GatherAndCompare(m.registry, strings.NewReader(expected), "hustoj_queue_depth"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,194 @@
+package daemon
+
+import "sync"
+
+// slotPool shares the OJ_RUNNING slots of a daemon among its sites. While
+// other sites are waiting for slots, a site gets no more than its share,
+// given by OJ_SITE_WEIGHT; while they are not, it may use any free slot.
+// The pool also hands out the client ids, so runners of different sites
+// never share a CPU or a work directory.
+type slotPool struct {
+	mu       sync.Mutex
+	capacity int
+	sites    map[string]*poolSite
+	slots    map[int]poolSlot // Maps clientID to the job holding it
+}
+
+type poolSite struct {
+	weight  int
+	used    int
+	waiting bool          // Has jobs the pool refused slots for
+	freed   chan struct{} // Signalled when another site releases slots
+}
+
+type poolSlot struct {
+	site   string
+	weight int
+	memory int  // MB reserved by memory admission
+	lost   bool // Killed, but the client has not exited yet
+}
+
+func newSlotPool(capacity int) *slotPool {
+	return &slotPool{
+		capacity: capacity,
+		sites:    make(map[string]*poolSite),
+		slots:    make(map[int]poolSlot),
+	}
+}
+
+// add registers a site and returns the channel signalled when slots it may
+// be waiting for are released.
+func (p *slotPool) add(site string, weight int) <-chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &poolSite{weight: max(weight, 1), freed: make(chan struct{}, 1)}
+	p.sites[site] = s
+	return s.freed
+}
+
+// acquire takes weight slots for a job of site and returns its client id,
+// or -1 if the slots are taken or the site would exceed its share while a
+// site below its own is waiting. A language heavier than all slots still
+// runs, but only on an idle pool.
+func (p *slotPool) acquire(site string, weight, memory int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.sites[site]
+	weight = min(max(weight, 1), p.capacity)
+	if p.used()+weight > p.capacity {
+		return -1
+	}
+	if float64(s.used+weight) > p.share(s) && p.othersWaiting(site) {
+		return -1
+	}
+	clientID := 0
+	for ; ; clientID++ {
+		if _, taken := p.slots[clientID]; !taken {
+			break
+		}
+	}
+	p.slots[clientID] = poolSlot{site: site, weight: weight, memory: memory}
+	s.used += weight
+	return clientID
+}
+
+// release frees the slots of a finished job and tells the other sites.
+func (p *slotPool) release(clientID int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	slot, ok := p.slots[clientID]
+	if !ok {
+		return
+	}
+	delete(p.slots, clientID)
+	p.sites[slot.site].used -= slot.weight
+	p.notify(slot.site)
+}
+
+// lose frees the slots of a job whose client did not exit after it was
+// killed. Its client id stays taken until release.
+func (p *slotPool) lose(clientID int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	slot, ok := p.slots[clientID]
+	if !ok {
+		return
+	}
+	p.sites[slot.site].used -= slot.weight
+	p.slots[clientID] = poolSlot{site: slot.site, lost: true}
+	p.notify("")
+}
+
+// setWaiting records whether site has jobs the pool refused slots for. A
+// site that stops waiting lets the others take its share.
+func (p *slotPool) setWaiting(site string, waiting bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.sites[site]
+	if s.waiting && !waiting {
+		p.notify(site)
+	}
+	s.waiting = waiting
+}
+
+// resize changes the number of slots. Running jobs keep theirs when it
+// shrinks; no new job starts until enough of them finished.
+func (p *slotPool) resize(capacity int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.capacity = capacity
+	p.notify("")
+}
+
+func (p *slotPool) setWeight(site string, weight int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sites[site].weight = max(weight, 1)
+	p.notify(site)
+}
+
+// memory returns the MB reserved by the running jobs of all sites.
+func (p *slotPool) memory() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, slot := range p.slots {
+		n += slot.memory
+	}
+	return n
+}
+
+// idle reports whether no site is judging. Lost clients do not count.
+func (p *slotPool) idle() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, slot := range p.slots {
+		if !slot.lost {
+			return false
+		}
+	}
+	return true
+}
+
+// used must be called with p.mu held.
+func (p *slotPool) used() int {
+	n := 0
+	for _, s := range p.sites {
+		n += s.used
+	}
+	return n
+}
+
+// share returns the slots s is entitled to while others are waiting. Must be
+// called with p.mu held.
+func (p *slotPool) share(s *poolSite) float64 {
+	total := 0
+	for _, o := range p.sites {
+		total += o.weight
+	}
+	return float64(p.capacity*s.weight) / float64(total)
+}
+
+// othersWaiting reports whether a site other than site waits for slots
+// while below its share. Must be called with p.mu held.
+func (p *slotPool) othersWaiting(site string) bool {
+	for name, o := range p.sites {
+		if name != site && o.waiting && float64(o.used) < p.share(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify wakes every site other than except. Must be called with p.mu held.
+func (p *slotPool) notify(except string) {
+	for name, s := range p.sites {
+		if name == except {
+			continue
+		}
+		select {
+		case s.freed <- struct{}{}:
+		default: // A wake-up is already pending
+		}
+	}
+}
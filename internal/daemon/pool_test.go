@@ -0,0 +1,128 @@
+package daemon
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+func TestSlotPoolShares(t *testing.T) {
+	p := newSlotPool(4)
+	p.add("school", 1)
+	contestFreed := p.add("contest", 3)
+
+	// An idle site leaves its share to the others.
+	var school []int
+	for i := 0; i < 4; i++ {
+		id := p.acquire("school", 1, 0)
+		if id == -1 {
+			t.Fatalf("school job %d refused while contest is idle", i)
+		}
+		school = append(school, id)
+	}
+	if id := p.acquire("contest", 1, 0); id != -1 {
+		t.Fatalf("contest got client %d from a full pool", id)
+	}
+
+	// Once contest waits, school only gets slots below its share of 1.
+	p.setWaiting("contest", true)
+	p.release(school[0])
+	select {
+	case <-contestFreed:
+	default:
+		t.Error("contest was not told about the released slot")
+	}
+	if id := p.acquire("school", 1, 0); id != -1 {
+		t.Error("school exceeded its share while contest waits")
+	}
+	id := p.acquire("contest", 1, 0)
+	if id != school[0] {
+		t.Errorf("contest got client %d; want the released %d", id, school[0])
+	}
+
+	// A contest that stops waiting hands the slots back.
+	p.setWaiting("contest", false)
+	p.release(school[1])
+	if p.acquire("school", 1, 0) == -1 {
+		t.Error("school refused a free slot nobody waits for")
+	}
+}
+
+func TestSlotPoolHeavyJobOnIdlePool(t *testing.T) {
+	p := newSlotPool(2)
+	p.add("school", 1)
+	p.add("contest", 1)
+
+	id := p.acquire("school", 5, 100)
+	if id == -1 {
+		t.Fatal("heavy job refused on an idle pool")
+	}
+	if p.idle() || p.memory() != 100 {
+		t.Errorf("idle = %v, memory = %d; want false, 100", p.idle(), p.memory())
+	}
+	if p.acquire("contest", 1, 0) != -1 {
+		t.Error("contest got a slot next to a job taking all of them")
+	}
+	p.release(id)
+	if !p.idle() {
+		t.Error("pool not idle after the release")
+	}
+}
+
+func TestCtlAcrossSites(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "ctl.sock")
+	pool := newSlotPool(2)
+	var workers []*Worker
+	for _, site := range []string{"school", "contest"} {
+		cfg := &DaemonConfig{
+			JudgeConfig: &config.JudgeConfig{Judger: "j1", Site: site},
+			MaxRunning:  2,
+			SleepTime:   1,
+			SiteWeight:  1,
+		}
+		w := NewWorker(cfg, &stubFetcher{})
+		w.joinPool(pool)
+		workers = append(workers, w)
+	}
+	top := &DaemonConfig{JudgeConfig: &config.JudgeConfig{}, CtlSocket: socket}
+	defer startServices(context.Background(), top, workers)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		runWorkers(ctx, workers)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	out, err := ctl(t, socket, "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"site school", "site contest", "OJ_SITE_WEIGHT=1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("status does not contain %q:\n%s", want, out)
+		}
+	}
+
+	if _, err := ctl(t, socket, "kill", "1"); err == nil || !strings.Contains(err.Error(), "--site") {
+		t.Errorf("kill without a site: %v; want an error asking for --site", err)
+	}
+	var sb strings.Builder
+	if err := Ctl(socket, "contest", []string{"pause"}, &sb); err != nil {
+		t.Fatal(err)
+	}
+	out, _ = ctl(t, socket, "status")
+	if !strings.Contains(out, "site school\npid") || !strings.Contains(out, "paused") {
+		t.Errorf("status after pausing contest:\n%s", out)
+	}
+	if err := Ctl(socket, "nosuchsite", []string{"status"}, &sb); err == nil {
+		t.Error("status of an unknown site succeeded")
+	}
+}
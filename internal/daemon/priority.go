@@ -0,0 +1,167 @@
+package daemon
+
+import (
+	"cmp"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// jobClass orders pending jobs; lower classes are started first.
+type jobClass int
+
+const (
+	classContest jobClass = iota // Submitted to a contest that is running now
+	classNormal                  // Practice submission
+	classRejudge                 // Rejudge (OJ_WT1)
+)
+
+// Job describes a pending submission to the priority policy.
+type Job struct {
+	SolutionID  int
+	UserID      string // Empty if the fetcher cannot tell
+	Language    int    // -1 if the fetcher cannot tell
+	ContestID   int
+	InContest   bool // Belongs to a contest that is running now
+	Rejudge     bool // Waiting for a rejudge
+	MemoryLimit int  // MB allowed by the problem, 0 if the fetcher cannot tell
+}
+
+func (j Job) class() jobClass {
+	switch {
+	case j.InContest:
+		return classContest
+	case j.Rejudge:
+		return classRejudge
+	default:
+		return classNormal
+	}
+}
+
+// JobDescriber is implemented by fetchers that can tell the worker more
+// about pending jobs than their IDs. Describe returns the jobs in the order
+// of solutionIDs.
+type JobDescriber interface {
+	Describe(solutionIDs []int) ([]Job, error)
+}
+
+// PriorityPolicy decides in which order pending jobs are started. Order is
+// called once per poll, Started for every job that was checked out.
+type PriorityPolicy interface {
+	Order(jobs []Job) []Job
+	Started(job Job)
+}
+
+// classPolicy starts running-contest submissions first, then practice
+// submissions, then rejudges, keeping queue order within a class. After
+// maxSkips jobs were started ahead of a waiting lower class, the oldest job
+// of that class goes first, so rejudges still move under contest load.
+type classPolicy struct {
+	maxSkips int // 0 disables the starvation guard
+	skips    int
+	lowest   jobClass // Lowest class waiting at the last Order call
+}
+
+func newPriorityPolicy(cfg *DaemonConfig) PriorityPolicy {
+	var policy PriorityPolicy = &classPolicy{maxSkips: cfg.StarveLimit}
+	if cfg.FairShare != fairShareOff {
+		policy = &fairPolicy{PriorityPolicy: policy, byContest: cfg.FairShare == fairShareContest}
+	}
+	return policy
+}
+
+func (p *classPolicy) Order(jobs []Job) []Job {
+	sorted := slices.Clone(jobs)
+	slices.SortStableFunc(sorted, func(a, b Job) int { return cmp.Compare(a.class(), b.class()) })
+	if len(sorted) == 0 {
+		return sorted
+	}
+
+	p.lowest = sorted[len(sorted)-1].class()
+	if p.maxSkips > 0 && p.skips >= p.maxSkips && p.lowest > sorted[0].class() {
+		i := slices.IndexFunc(sorted, func(j Job) bool { return j.class() == p.lowest })
+		starved := sorted[i]
+		copy(sorted[1:i+1], sorted[:i])
+		sorted[0] = starved
+	}
+	return sorted
+}
+
+func (p *classPolicy) Started(job Job) {
+	if job.class() >= p.lowest {
+		p.skips = 0
+	} else {
+		p.skips++
+	}
+}
+
+// describe asks the fetcher about jobs. Without a JobDescriber, or when it
+// fails, every job counts as a practice submission and queue order is kept.
+func (w *Worker) describe(solutionIDs []int) []Job {
+	if d, ok := w.fetcher.(JobDescriber); ok {
+		jobs, err := d.Describe(solutionIDs)
+		if err == nil {
+			return jobs
+		}
+		slog.Warn("Could not describe pending jobs, using queue order", "err", err)
+	}
+	return plainJobs(solutionIDs)
+}
+
+func plainJobs(solutionIDs []int) []Job {
+	jobs := make([]Job, len(solutionIDs))
+	for i, id := range solutionIDs {
+		jobs[i] = Job{SolutionID: id, Language: -1}
+	}
+	return jobs
+}
+
+// describeJobs looks up the rejudge and running-contest state and the
+// memory limit of solutions.
+func describeJobs(db *sql.DB, solutionIDs []int) ([]Job, error) {
+	if len(solutionIDs) == 0 {
+		return nil, nil
+	}
+
+	args := make([]any, len(solutionIDs))
+	for i, id := range solutionIDs {
+		args[i] = id
+	}
+	query := fmt.Sprintf(`SELECT s.solution_id, s.result, s.user_id, s.language, s.contest_id, c.contest_id IS NOT NULL,
+		COALESCE(p.memory_limit, 0) FROM solution s
+		LEFT JOIN contest c ON c.contest_id = s.contest_id AND c.start_time <= NOW() AND c.end_time > NOW()
+		LEFT JOIN problem p ON p.problem_id = s.problem_id
+		WHERE s.solution_id IN (%s)`, strings.TrimSuffix(strings.Repeat("?,", len(args)), ","))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error describing jobs: %w", err)
+	}
+	defer rows.Close()
+
+	known := make(map[int]Job, len(solutionIDs))
+	for rows.Next() {
+		var job Job
+		var result int
+		var contestID sql.NullInt64
+		if err := rows.Scan(&job.SolutionID, &result, &job.UserID, &job.Language, &contestID, &job.InContest, &job.MemoryLimit); err != nil {
+			return nil, err
+		}
+		job.ContestID = int(contestID.Int64)
+		job.Rejudge = result == OJ_WT1
+		known[job.SolutionID] = job
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	jobs := plainJobs(solutionIDs)
+	for i, job := range jobs {
+		if k, ok := known[job.SolutionID]; ok {
+			jobs[i] = k
+		}
+	}
+	return jobs, nil
+}
@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"fmt"
+	"testing"
+)
+
+func solutionIDs(jobs []Job) string {
+	ids := make([]int, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.SolutionID
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestClassPolicyOrder(t *testing.T) {
+	p := &classPolicy{}
+	jobs := []Job{
+		{SolutionID: 1, Rejudge: true},
+		{SolutionID: 2},
+		{SolutionID: 3, InContest: true},
+		{SolutionID: 4},
+		{SolutionID: 5, InContest: true, Rejudge: true},
+	}
+
+	if got := solutionIDs(p.Order(jobs)); got != "[3 5 2 4 1]" {
+		t.Errorf("Order = %v; want [3 5 2 4 1]", got)
+	}
+	if jobs[0].SolutionID != 1 {
+		t.Error("Order modified its input")
+	}
+}
+
+func TestClassPolicyStarvationGuard(t *testing.T) {
+	p := &classPolicy{maxSkips: 2}
+	rejudge := Job{SolutionID: 1, Rejudge: true}
+
+	// Contest submissions keep arriving while a rejudge waits.
+	var started []int
+	for sid := 100; sid < 106; sid++ {
+		next := p.Order([]Job{rejudge, {SolutionID: sid, InContest: true}})[0]
+		p.Started(next)
+		started = append(started, next.SolutionID)
+		if next == rejudge {
+			break
+		}
+	}
+
+	if fmt.Sprint(started) != "[100 101 1]" {
+		t.Errorf("started %v; want the rejudge after two contest jobs", started)
+	}
+	if p.skips != 0 {
+		t.Errorf("skips = %d after the starved job ran; want 0", p.skips)
+	}
+}
+
+func TestClassPolicyNoGuardWithoutLowerClass(t *testing.T) {
+	p := &classPolicy{maxSkips: 1}
+	for sid := 1; sid <= 3; sid++ {
+		p.Started(p.Order([]Job{{SolutionID: sid}})[0])
+	}
+	if p.skips != 0 {
+		t.Errorf("skips = %d with nothing waiting; want 0", p.skips)
+	}
+}
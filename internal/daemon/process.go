@@ -1,20 +1,51 @@
 package daemon
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
+	"runtime/debug"
 	"strconv"
+
+	"github.com/sempr/hustoj-go/internal/client"
+	"github.com/sempr/hustoj-go/pkg/events"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+	"github.com/sempr/hustoj-go/pkg/language"
+	"github.com/sempr/hustoj-go/pkg/models"
+	"github.com/sempr/hustoj-go/pkg/repository"
 )
 
 const STD_MB = 1048576
 
+// jobResult is sent on the worker's done channel when a client exits.
+type jobResult struct {
+	clientID int
+	report   *models.JudgeReport // nil if the client exited without a report
+	output   string              // Tail of the client's output, for diagnostics
+}
+
+// maxClientOutput bounds how much client output is kept for runtimeinfo.
+const maxClientOutput = 8 << 10
+
+// outputTail returns the last maxClientOutput bytes of b.
+func outputTail(b []byte) string {
+	if len(b) > maxClientOutput {
+		b = b[len(b)-maxClientOutput:]
+	}
+	return string(b)
+}
+
 // RunClient executes the judge_client or a Docker container.
 // It calls a platform-specific setResourceLimits function.
-func RunClient(cfg *DaemonConfig, solutionID, clientID int, done chan<- int) {
+// Cancelling ctx kills the client together with its sandbox processes.
+func RunClient(ctx context.Context, cfg *DaemonConfig, solutionID, clientID int, done chan<- jobResult) {
+	res := jobResult{clientID: clientID}
 	defer func() {
-		done <- clientID // Notify that the job has finished
+		done <- res // Notify that the job has finished
 	}()
 
 	solutionIDStr := strconv.Itoa(solutionID)
@@ -22,8 +53,10 @@ func RunClient(cfg *DaemonConfig, solutionID, clientID int, done chan<- int) {
 
 	var cmd *exec.Cmd
 	selfexe, _ := os.Executable()
-	fmt.Printf("%s client %s %s %s\n", selfexe, solutionIDStr, clientIDStr, cfg.OJHome)
-	cmd = exec.Command(selfexe, "client", solutionIDStr, clientIDStr, cfg.OJHome)
+	home := cfg.confHome()
+	fmt.Printf("%s client %s %s %s\n", selfexe, solutionIDStr, clientIDStr, home)
+	cmd = exec.CommandContext(ctx, selfexe, "client", solutionIDStr, clientIDStr, home)
+	cmd.Cancel = func() error { return killProcessTree(cmd) }
 
 	// This function call will be resolved at compile time to the correct
 	// OS-specific implementation.
@@ -31,8 +64,102 @@ func RunClient(cfg *DaemonConfig, solutionID, clientID int, done chan<- int) {
 		slog.Warn("Failed to set resource limits", "solution_id", solutionID, "err", err)
 	}
 
-	output, err := cmd.CombinedOutput()
+	// The client writes its JudgeReport to fd 3.
+	r, w, err := os.Pipe()
+	if err != nil {
+		slog.Error("Failed to create report pipe", "solution_id", solutionID, "err", err)
+		return
+	}
+	defer r.Close()
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.Env = append(os.Environ(), client.ReportFDEnv+"=3", client.InstanceEnv+"="+cfg.Instance, client.SiteEnv+"="+cfg.Site)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		slog.Error("Failed to start client", "solution_id", solutionID, "err", err)
+		res.output = fmt.Sprintf("failed to start client: %v", err)
+		return
+	}
+	w.Close()
+
+	var report models.JudgeReport
+	if err := json.NewDecoder(r).Decode(&report); err == nil {
+		res.report = &report
+	}
+
+	err = cmd.Wait()
+	res.output = outputTail(output.Bytes())
+	if err != nil {
+		slog.Error("Failed to run client", "solution_id", solutionID, "err", err, "output", output.String())
+		res.output += fmt.Sprintf("\nclient exited: %v", err)
+	}
+}
+
+// sharedClient holds the database, language set and event sink of the
+// daemon. The daemon uses the database to record system errors of killed
+// judgements; with OJ_INTERNAL_CLIENT enabled every in-process judgement
+// reuses all three.
+type sharedClient struct {
+	db     interfaces.Database
+	langs  *language.Manager
+	events events.Sink // nil if not set up
+}
+
+func newSharedClient(cfg *DaemonConfig) (*sharedClient, error) {
+	db, err := repository.Open(cfg.JudgeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	langs, err := language.NewLanguageManager(cfg.confHome())
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize language manager: %w", err)
+	}
+
+	return &sharedClient{db: db, langs: langs, events: events.New(cfg.JudgeConfig)}, nil
+}
+
+func (s *sharedClient) Close() error {
+	if s.events != nil {
+		s.events.Close()
+	}
+	return s.db.Close()
+}
+
+// publish sends an event on behalf of a client that could not.
+func (s *sharedClient) publish(e events.Event) {
+	if s.events != nil {
+		s.events.Publish(e)
+	}
+}
+
+// RunInternalClient judges a submission inside the daemon process. The
+// sandbox is still started as a separate process for isolation.
+func RunInternalClient(ctx context.Context, cfg *DaemonConfig, shared *sharedClient, solutionID, clientID int, done chan<- jobResult) {
+	res := jobResult{clientID: clientID}
+	defer func() {
+		done <- res // Notify that the job has finished
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			stack := string(debug.Stack())
+			slog.Error("Judge client panicked", "solution_id", solutionID, "panic", r, "stack", stack)
+			res.output = outputTail([]byte(fmt.Sprintf("panic: %v\n\n%s", r, stack)))
+		}
+	}()
+
+	jc := client.NewSharedJudgeClient(cfg.JudgeConfig, shared.db, shared.langs, shared.events, solutionID, strconv.Itoa(clientID))
+	defer jc.Close()
+
+	err := jc.RunContext(ctx)
+	report := jc.Report()
+	res.report = &report
 	if err != nil {
-		slog.Error("Failed to run client", "solution_id", solutionID, "err", err, "output", string(output))
+		slog.Error("Judge process failed", "solution_id", solutionID, "err", err)
+		res.output = err.Error()
 	}
 }
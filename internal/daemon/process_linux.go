@@ -12,8 +12,10 @@ import (
 // This is the Linux-specific implementation of setResourceLimits.
 func setResourceLimits(cmd *exec.Cmd, cfg *DaemonConfig) error {
 	// Pdeathsig ensures the child process is killed if the parent (judged) dies.
+	// Setpgid lets killProcessTree signal the client and its sandboxes at once.
 	cmd.SysProcAttr = &unix.SysProcAttr{
 		Pdeathsig: unix.SIGKILL,
+		Setpgid:   true,
 	}
 
 	// Wrapper function to simplify setting a resource limit.
@@ -42,3 +44,9 @@ func setResourceLimits(cmd *exec.Cmd, cfg *DaemonConfig) error {
 
 	return nil
 }
+
+// killProcessTree kills the client's whole process group. Traced programs
+// die with their sandbox because of PTRACE_O_EXITKILL.
+func killProcessTree(cmd *exec.Cmd) error {
+	return unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+}
@@ -12,3 +12,8 @@ func setResourceLimits(cmd *exec.Cmd, cfg *DaemonConfig) error {
 	slog.Warn("Resource limits (rlimit) are not supported on this OS. Running without restrictions.")
 	return nil
 }
+
+// killProcessTree kills the client process.
+func killProcessTree(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,59 @@
+package daemon
+
+import "slices"
+
+// jobQueue holds pending jobs prefetched from the fetcher, so a freed slot
+// is filled from memory instead of another query. The fetcher is asked
+// again only when the queue runs low or may be out of date.
+type jobQueue struct {
+	jobs  []Job
+	stale bool // Poll interval passed or woken up: new jobs may be pending
+	more  bool // The last fetch found jobs, so there may be more
+}
+
+// needsFetch reports whether the queue should be refilled before
+// dispatching to free slots. lowWater is the length below which the queue
+// counts as running low.
+func (q *jobQueue) needsFetch(lowWater int) bool {
+	return q.stale || (q.more && len(q.jobs) < lowWater)
+}
+
+// replace sets the queue to the jobs of a fresh fetch, dropping duplicates
+// and the jobs for which skip returns true. Memory limits looked up for
+// jobs that were already queued are kept.
+func (q *jobQueue) replace(jobs []Job, skip func(solutionID int) bool) {
+	limits := make(map[int]int)
+	for _, job := range q.jobs {
+		if job.MemoryLimit > 0 {
+			limits[job.SolutionID] = job.MemoryLimit
+		}
+	}
+	q.jobs = q.jobs[:0]
+	seen := make(map[int]bool, len(jobs))
+	for _, job := range jobs {
+		if seen[job.SolutionID] || skip(job.SolutionID) {
+			continue
+		}
+		seen[job.SolutionID] = true
+		if job.MemoryLimit <= 0 {
+			job.MemoryLimit = limits[job.SolutionID]
+		}
+		q.jobs = append(q.jobs, job)
+	}
+	q.stale = false
+	q.more = len(jobs) > 0
+}
+
+// setMemoryLimit records the memory limit looked up for a queued job.
+func (q *jobQueue) setMemoryLimit(solutionID, limit int) {
+	for i := range q.jobs {
+		if q.jobs[i].SolutionID == solutionID {
+			q.jobs[i].MemoryLimit = limit
+		}
+	}
+}
+
+// remove drops a job that was started or taken by another judger.
+func (q *jobQueue) remove(solutionID int) {
+	q.jobs = slices.DeleteFunc(q.jobs, func(j Job) bool { return j.SolutionID == solutionID })
+}
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobQueueReplace(t *testing.T) {
+	q := jobQueue{stale: true}
+	q.replace(plainJobs([]int{5, 6, 6, 7, 5}), func(id int) bool { return id == 7 })
+
+	if got := solutionIDs(q.jobs); got != "[5 6]" {
+		t.Errorf("queue = %v; want [5 6]", got)
+	}
+	if q.stale || !q.more {
+		t.Errorf("stale = %v, more = %v after a fetch with jobs", q.stale, q.more)
+	}
+
+	q.remove(5)
+	if got := solutionIDs(q.jobs); got != "[6]" {
+		t.Errorf("queue after remove = %v; want [6]", got)
+	}
+	if !q.needsFetch(2) {
+		t.Error("queue below the low water mark does not ask for a fetch")
+	}
+
+	q.replace(nil, func(int) bool { return false })
+	if q.needsFetch(2) {
+		t.Error("empty fetch must not be repeated before the next poll")
+	}
+	q.stale = true
+	if !q.needsFetch(2) {
+		t.Error("stale queue does not ask for a fetch")
+	}
+}
+
+func TestWorkDoesNotFetchWhenBusy(t *testing.T) {
+	f := &stubFetcher{jobs: []int{1, 2}}
+	w := NewWorker(&DaemonConfig{MaxRunning: 1}, f)
+	startFakeJob(w, 0, 100, time.Hour)
+	defer w.drain() // Kills the fake job
+
+	if n := w.work(); n != 0 {
+		t.Errorf("work started %d jobs with no free slot", n)
+	}
+	if f.fetches != 0 {
+		t.Errorf("fetched %d times with no free slot; want 0", f.fetches)
+	}
+}
+
+func TestRefillSkipsRunningJobs(t *testing.T) {
+	f := &stubFetcher{jobs: []int{100, 101, 101, 102}}
+	w := NewWorker(&DaemonConfig{MaxRunning: 2}, f)
+	startFakeJob(w, 0, 100, time.Hour)
+	defer w.drain() // Kills the fake job
+
+	w.refill()
+	if got := solutionIDs(w.queue.jobs); got != "[101 102]" {
+		t.Errorf("queue = %v; want [101 102]", got)
+	}
+}
@@ -0,0 +1,32 @@
+package daemon
+
+import (
+	"log/slog"
+
+	"github.com/sempr/hustoj-go/internal/client"
+	"github.com/sempr/hustoj-go/internal/sandbox"
+)
+
+// recoverOrphans cleans up after a previous daemon that died mid-judgement:
+// solutions it checked out go back to the queue, and its runner mounts and
+// per-run cgroups are removed. It runs before the worker starts any job.
+func recoverOrphans(cfg *DaemonConfig, fetcher JobFetcher) {
+	if recoverer, ok := fetcher.(JobRecoverer); ok {
+		n, err := recoverer.Recover()
+		if err != nil {
+			slog.Error("Could not recover orphaned solutions", "err", err)
+		} else if n > 0 {
+			slog.Warn("Requeued solutions orphaned by a previous run", "count", n)
+		}
+	}
+
+	if err := client.CleanupRunDirs(cfg.OJHome, cfg.Namespace()); err != nil {
+		slog.Warn("Could not clean up runner directories", "err", err)
+	}
+
+	if n, err := sandbox.CleanupStaleCgroups(cfg.Namespace()); err != nil {
+		slog.Warn("Could not clean up stale cgroups", "err", err)
+	} else if n > 0 {
+		slog.Info("Removed stale cgroups", "count", n)
+	}
+}
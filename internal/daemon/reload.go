@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// restartSettings cannot change while the daemon runs: connections, queues
+// and listeners are set up from them once at start. A reload that changes
+// one of them keeps the old value.
+var restartSettings = []struct {
+	key   string
+	value func(*DaemonConfig) any
+}{
+	{"OJ_DB_DRIVER", func(c *DaemonConfig) any { return c.Database.Driver }},
+	{"OJ_DB_PATH", func(c *DaemonConfig) any { return c.Database.Path }},
+	{"OJ_HOST_NAME", func(c *DaemonConfig) any { return c.Database.Host }},
+	{"OJ_PORT_NUMBER", func(c *DaemonConfig) any { return c.Database.Port }},
+	{"OJ_USER_NAME", func(c *DaemonConfig) any { return c.Database.User }},
+	{"OJ_PASSWORD", func(c *DaemonConfig) any { return c.Database.Password }},
+	{"OJ_DB_NAME", func(c *DaemonConfig) any { return c.Database.Name }},
+	{"OJ_INSTANCE", func(c *DaemonConfig) any { return c.Instance }},
+	{"OJ_HOME", func(c *DaemonConfig) any { return c.OJHome }},
+	{"OJ_JUDGER_NAME", func(c *DaemonConfig) any { return c.Judger }},
+	{"OJ_HTTP_*", func(c *DaemonConfig) any { return c.HTTP }},
+	{"OJ_CPU_*", func(c *DaemonConfig) any { return c.CPU }},
+	{"OJ_EVENT_*", func(c *DaemonConfig) any { return c.Events }},
+	{"OJ_PROGRESS_*", func(c *DaemonConfig) any { return c.Progress }},
+	{"OJ_REDISENABLE", func(c *DaemonConfig) any { return c.RedisEnable }},
+	{"OJ_REDISSERVER", func(c *DaemonConfig) any { return c.RedisServer }},
+	{"OJ_REDISPORT", func(c *DaemonConfig) any { return c.RedisPort }},
+	{"OJ_REDISAUTH", func(c *DaemonConfig) any { return c.RedisAuth }},
+	{"OJ_REDISQNAME", func(c *DaemonConfig) any { return c.RedisQName }},
+	{"OJ_REDIS_REQUEUE_TIMEOUT", func(c *DaemonConfig) any { return c.RedisRequeue }},
+	{"OJ_TOTAL", func(c *DaemonConfig) any { return c.TotalJudges }},
+	{"OJ_MOD", func(c *DaemonConfig) any { return c.JudgeMod }},
+	{"OJ_LEASE_TIME", func(c *DaemonConfig) any { return c.LeaseTime }},
+	{"OJ_UDP_ENABLE", func(c *DaemonConfig) any { return c.UDPEnable }},
+	{"OJ_UDP_SERVER", func(c *DaemonConfig) any { return c.UDPServer }},
+	{"OJ_UDP_PORT", func(c *DaemonConfig) any { return c.UDPPort }},
+	{"OJ_USE_DOCKER", func(c *DaemonConfig) any { return c.UseDocker }},
+	{"OJ_DOCKER_PATH", func(c *DaemonConfig) any { return c.DockerPath }},
+	{"OJ_INTERNAL_CLIENT", func(c *DaemonConfig) any { return c.InternalClient }},
+	{"OJ_METRICS_ADDR", func(c *DaemonConfig) any { return c.MetricsAddr }},
+	{"OJ_CTL_SOCKET", func(c *DaemonConfig) any { return c.CtlSocket }},
+}
+
+// validate rejects settings the worker cannot run with, so a reload with a
+// typo keeps the old configuration.
+func (cfg *DaemonConfig) validate() error {
+	if cfg.MaxRunning < 1 {
+		return fmt.Errorf("OJ_RUNNING must be at least 1, got %d", cfg.MaxRunning)
+	}
+	if cfg.SleepTime < 1 {
+		return fmt.Errorf("OJ_SLEEP_TIME must be at least 1, got %d", cfg.SleepTime)
+	}
+	if cfg.Site != "" && cfg.SiteWeight < 1 {
+		return fmt.Errorf("OJ_SITE_WEIGHT must be at least 1, got %d", cfg.SiteWeight)
+	}
+	for _, id := range strings.Split(cfg.LangSet, ",") {
+		if _, err := strconv.Atoi(strings.TrimSpace(id)); err != nil {
+			return fmt.Errorf("OJ_LANG_SET must list language ids, got %q", cfg.LangSet)
+		}
+	}
+	return nil
+}
+
+// Reload hands a configuration read after SIGHUP to the worker loop.
+func (w *Worker) Reload(next *DaemonConfig) {
+	select {
+	case w.reloads <- next:
+	default:
+		slog.Warn("A reload is already pending, ignoring this one")
+	}
+}
+
+// reload applies the settings of next that can change while jobs run and
+// logs those that need a restart. Running judgements keep their slots when
+// OJ_RUNNING shrinks; no new job starts until enough of them finished.
+func (w *Worker) reload(next *DaemonConfig) {
+	if err := next.validate(); err != nil {
+		slog.Error("Invalid configuration, keeping the old one", "err", err)
+		return
+	}
+	for _, s := range restartSettings {
+		if !reflect.DeepEqual(s.value(w.cfg), s.value(next)) {
+			slog.Error("Setting cannot change without a restart, keeping the old value", "key", s.key)
+		}
+	}
+
+	cfg := w.cfg
+	changed := func(key string, old, new any) bool {
+		if old == new {
+			return false
+		}
+		slog.Info("Applying new setting", "key", key, "from", old, "to", new)
+		return true
+	}
+	fetchChanged := changed("OJ_RUNNING", cfg.MaxRunning, next.MaxRunning)
+	fetchChanged = changed("OJ_LANG_SET", cfg.LangSet, next.LangSet) || fetchChanged
+	cfg.MaxRunning, cfg.LangSet = next.MaxRunning, next.LangSet
+	if changed("OJ_SLEEP_TIME", cfg.SleepTime, next.SleepTime) {
+		cfg.SleepTime = next.SleepTime
+	}
+	starveChanged := changed("OJ_PRIORITY_STARVATION", cfg.StarveLimit, next.StarveLimit)
+	if changed("OJ_FAIR_SHARE", cfg.FairShare, next.FairShare) || starveChanged {
+		cfg.StarveLimit, cfg.FairShare = next.StarveLimit, next.FairShare
+		w.policy = newPriorityPolicy(cfg)
+	}
+	if changed("OJ_USER_MAX_RUNNING", cfg.UserSlots, next.UserSlots) {
+		cfg.UserSlots = next.UserSlots
+	}
+	if changed("OJ_JUDGE_TIMEOUT", cfg.JudgeTimeout, next.JudgeTimeout) {
+		cfg.JudgeTimeout = next.JudgeTimeout
+	}
+	if changed("OJ_CLIENT_RETRIES", cfg.ClientRetries, next.ClientRetries) {
+		cfg.ClientRetries = next.ClientRetries
+	}
+	if changed("OJ_DRAIN_TIMEOUT", cfg.DrainTimeout, next.DrainTimeout) {
+		cfg.DrainTimeout = next.DrainTimeout
+	}
+	if changed("OJ_MEMORY_ADMISSION", cfg.MemoryAdmission, next.MemoryAdmission) {
+		cfg.MemoryAdmission = next.MemoryAdmission
+	}
+	if changed("OJ_MEMORY_RESERVE", cfg.MemoryReserve, next.MemoryReserve) {
+		cfg.MemoryReserve = next.MemoryReserve
+	}
+	if changed("OJ_LOAD_LIMIT", cfg.LoadLimit, next.LoadLimit) {
+		cfg.LoadLimit = next.LoadLimit
+	}
+	if changed("OJ_LANG_BREAKER", cfg.LangBreaker, next.LangBreaker) {
+		cfg.LangBreaker = next.LangBreaker
+		if cfg.LangBreaker <= 0 {
+			w.enableAllLanguages()
+		}
+	}
+	if changed("OJ_SITE_WEIGHT", cfg.SiteWeight, next.SiteWeight) {
+		cfg.SiteWeight = next.SiteWeight
+		if w.pool != nil {
+			w.pool.setWeight(w.site, cfg.SiteWeight)
+		}
+	}
+
+	w.metrics.maxRunning.Set(float64(cfg.MaxRunning))
+	if fetchChanged {
+		w.reconfigureFetcher()
+	}
+	w.reloadLanguages()
+	w.queue.stale = true
+	slog.Info("Configuration reloaded")
+}
+
+// reloadLanguages picks up languages added to all.toml and re-reads the
+// [sched] tables. A file that fails to parse keeps its old contents.
+func (w *Worker) reloadLanguages() {
+	if w.shared == nil || w.shared.langs == nil {
+		return
+	}
+	if err := w.shared.langs.Reload(); err != nil {
+		slog.Error("Could not reload languages, keeping the old ones", "err", err)
+	}
+	for langID := range w.sched {
+		lc, err := w.shared.langs.GetLanguageConfig(langID)
+		if err != nil {
+			slog.Error("Could not reload language config, keeping the old one", "language", langID, "err", err)
+			continue
+		}
+		w.sched[langID] = lc.Sched
+	}
+}
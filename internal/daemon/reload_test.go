@@ -0,0 +1,70 @@
+package daemon
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+func reloadTestConfig() *DaemonConfig {
+	return &DaemonConfig{
+		JudgeConfig: &config.JudgeConfig{Database: config.DatabaseConfig{Host: "db1"}},
+		MaxRunning:  2,
+		SleepTime:   1,
+		LangSet:     "0,1",
+	}
+}
+
+func TestReloadAppliesLiveSettings(t *testing.T) {
+	f := newTestSQLiteFetcher(t, "j1")
+	cfg := reloadTestConfig()
+	w := NewWorker(cfg, f)
+	w.queue.stale = false
+
+	next := reloadTestConfig()
+	next.JudgeConfig.Database.Host = "db2"
+	next.MaxRunning = 4
+	next.SleepTime = 5
+	next.LangSet = "2"
+	next.UserSlots = 1
+	w.reload(next)
+
+	if cfg.MaxRunning != 4 || cfg.SleepTime != 5 || cfg.LangSet != "2" || cfg.UserSlots != 1 {
+		t.Errorf("live settings not applied: %+v", cfg)
+	}
+	if cfg.Database.Host != "db1" {
+		t.Errorf("database host = %q; a reload must not change it", cfg.Database.Host)
+	}
+	if !w.queue.stale {
+		t.Error("queue not refreshed after reload")
+	}
+	jobs, err := f.GetJobs(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3}; !slices.Equal(jobs, want) {
+		t.Errorf("GetJobs after reload = %v; want %v from the new OJ_LANG_SET", jobs, want)
+	}
+}
+
+func TestReloadKeepsConfigOnInvalidValues(t *testing.T) {
+	for name, breakIt := range map[string]func(*DaemonConfig){
+		"running":  func(c *DaemonConfig) { c.MaxRunning = 0 },
+		"sleep":    func(c *DaemonConfig) { c.SleepTime = 0 },
+		"lang set": func(c *DaemonConfig) { c.LangSet = "0,1) OR (1=1" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := reloadTestConfig()
+			w := NewWorker(cfg, &stubFetcher{})
+			next := reloadTestConfig()
+			next.MaxRunning = 5
+			breakIt(next)
+			w.reload(next)
+
+			if cfg.MaxRunning != 2 || cfg.SleepTime != 1 || cfg.LangSet != "0,1" {
+				t.Errorf("config changed by an invalid reload: %+v", cfg)
+			}
+		})
+	}
+}
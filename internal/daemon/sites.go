@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+// loadSites returns the configuration of each [site.<name>] section of the
+// judge.conf of the instance, or just top for a single-site judge.conf.
+func loadSites(top *DaemonConfig) ([]*DaemonConfig, error) {
+	names, err := config.ConfSites(config.ConfPath(".", daemonArgs.Instance))
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return []*DaemonConfig{top}, nil
+	}
+	sites := make([]*DaemonConfig, 0, len(names))
+	for _, name := range names {
+		cfg, err := LoadDaemonConfig(".", daemonArgs.Instance, name)
+		if err != nil {
+			return nil, fmt.Errorf("site %s: %w", name, err)
+		}
+		applyArgs(cfg)
+		sites = append(sites, cfg)
+	}
+	return sites, nil
+}
+
+// newSiteWorker connects to the job queue and the database of a site,
+// waiting for them if they are down, and cleans up after a previous daemon
+// before the worker starts any job. It gives up only when ctx is cancelled.
+func newSiteWorker(ctx context.Context, cfg *DaemonConfig) (*Worker, error) {
+	var fetcher JobFetcher
+	if err := retryStartup(ctx, "job fetcher", func() (err error) {
+		fetcher, err = NewFetcher(cfg)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("could not create fetcher: %w", err)
+	}
+	recoverOrphans(cfg, fetcher)
+
+	worker := NewWorker(cfg, fetcher)
+	if err := retryStartup(ctx, "judge database", func() (err error) {
+		worker.shared, err = newSharedClient(cfg)
+		return err
+	}); err != nil {
+		fetcher.Close()
+		return nil, fmt.Errorf("could not connect to the judge database: %w", err)
+	}
+	return worker, nil
+}
+
+// close releases the connections of a worker whose loop has returned.
+func (w *Worker) close() {
+	w.shared.Close()
+	w.fetcher.Close()
+}
+
+// startServices starts the UDP wake-up listener and the control socket the
+// workers share, configured by the top of judge.conf. The returned
+// function removes the control socket.
+func startServices(ctx context.Context, cfg *DaemonConfig, workers []*Worker) (stop func()) {
+	if cfg.UDPEnable {
+		addr := fmt.Sprintf("%s:%d", cfg.UDPServer, cfg.UDPPort)
+		var wake []chan<- struct{}
+		for _, w := range workers {
+			wake = append(wake, w.wake)
+		}
+		waker, err := newUDPWaker(addr, wake...)
+		if err != nil {
+			slog.Warn("UDP wake-up disabled, falling back to polling", "err", err)
+		} else {
+			slog.Info("Listening for UDP wake-up", "addr", addr)
+			go waker.serve(ctx)
+		}
+	}
+	if cfg.CtlSocket != "" {
+		server, err := newCtlServer(cfg.CtlSocket, workers)
+		if err != nil {
+			slog.Warn("Control socket disabled", "err", err)
+		} else {
+			slog.Info("Listening for control commands", "socket", cfg.CtlSocket)
+			go server.serve()
+			return server.close
+		}
+	}
+	return func() {}
+}
+
+// runWorkers runs the loops of all sites and returns once every one has.
+func runWorkers(ctx context.Context, workers []*Worker) {
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Run(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// reloadSites hands the configurations re-read on SIGHUP to the workers.
+// Sites cannot be added or removed without a restart.
+func reloadSites(workers []*Worker, pool *slotPool, top *DaemonConfig, sites []*DaemonConfig) {
+	var current, next []string
+	for _, w := range workers {
+		current = append(current, w.site)
+	}
+	for _, cfg := range sites {
+		next = append(next, cfg.Site)
+	}
+	if !slices.Equal(current, next) {
+		slog.Error("Sites cannot change without a restart, keeping the old configuration",
+			"sites", current, "new_sites", next)
+		return
+	}
+	if pool != nil && top.MaxRunning >= 1 {
+		pool.resize(top.MaxRunning)
+	}
+	for i, w := range workers {
+		w.Reload(sites[i])
+	}
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/sempr/hustoj-go/pkg/language"
+)
+
+// usedSlots sums the weights of the running jobs.
+func (w *Worker) usedSlots() int {
+	n := 0
+	for _, job := range w.running {
+		n += max(job.weight, 1)
+	}
+	return n
+}
+
+// fits reports whether a job can start now without exceeding OJ_RUNNING or
+// the max_running of its language.
+func (w *Worker) fits(pending Job) bool {
+	if w.usedSlots()+w.langWeight(pending.Language) > w.cfg.MaxRunning {
+		return false
+	}
+	if sched := w.langSched(pending.Language); sched.MaxRunning > 0 {
+		n := 0
+		for _, job := range w.running {
+			if job.language == pending.Language {
+				n++
+			}
+		}
+		if n >= sched.MaxRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// heavyGuard keeps a heavy job from waiting forever while lighter jobs
+// take every slot that frees up. Once OJ_PRIORITY_STARVATION jobs were
+// started while it did not fit, no other job starts until it does.
+type heavyGuard struct {
+	solutionID int // Heavy job waiting for slots, 0 if none
+	skips      int // Jobs started since it first did not fit
+}
+
+// holds reports whether pending must wait for the starved heavy job.
+func (w *Worker) holds(pending Job) bool {
+	g := &w.heavy
+	if g.solutionID == 0 || g.solutionID == pending.SolutionID {
+		return false
+	}
+	if !slices.ContainsFunc(w.queue.jobs, func(j Job) bool { return j.SolutionID == g.solutionID }) {
+		*g = heavyGuard{} // Taken by another judger
+		return false
+	}
+	return w.cfg.StarveLimit > 0 && g.skips >= w.cfg.StarveLimit
+}
+
+// tooHeavy notes that pending did not fit because of its weight.
+func (w *Worker) tooHeavy(pending Job) {
+	if w.heavy.solutionID == 0 {
+		w.heavy = heavyGuard{solutionID: pending.SolutionID}
+	}
+}
+
+// startedJob counts a started job against the waiting heavy job.
+func (w *Worker) startedJob(job Job) {
+	switch w.heavy.solutionID {
+	case 0:
+	case job.SolutionID:
+		w.heavy = heavyGuard{}
+	default:
+		w.heavy.skips++
+	}
+}
+
+// langWeight returns the slots one judgement of langID takes. A language
+// heavier than all slots still runs, but only on an idle judger.
+func (w *Worker) langWeight(langID int) int {
+	return min(max(w.langSched(langID).Weight, 1), w.cfg.MaxRunning)
+}
+
+// langSched returns the [sched] table of a language, read once from its
+// lang.toml. Unknown languages have weight 1 and no limit.
+func (w *Worker) langSched(langID int) language.SchedInfo {
+	if langID < 0 || w.shared == nil || w.shared.langs == nil {
+		return language.SchedInfo{}
+	}
+	if sched, ok := w.sched[langID]; ok {
+		return sched
+	}
+
+	var sched language.SchedInfo
+	if lc, err := w.shared.langs.GetLanguageConfig(langID); err != nil {
+		slog.Warn("Could not read language config for scheduling", "language", langID, "err", err)
+	} else {
+		sched = lc.Sched
+	}
+	w.sched[langID] = sched
+	return sched
+}
@@ -0,0 +1,112 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sempr/hustoj-go/pkg/language"
+)
+
+func newSlotsTestWorker(t *testing.T, maxRunning int) *Worker {
+	t.Helper()
+	home := t.TempDir()
+	langs := filepath.Join(home, "etc", "langs")
+	if err := os.MkdirAll(langs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"all.toml":    "[[lang]]\nname = \"C\"\nid = 0\n\n[[lang]]\nname = \"JAVA\"\nid = 3\n",
+		"0.lang.toml": "name = \"c\"\n",
+		"3.lang.toml": "name = \"java\"\n\n[sched]\nweight = 2\nmax_running = 1\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(langs, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manager, err := language.NewLanguageManager(home)
+	if err != nil {
+		t.Fatalf("NewLanguageManager: %v", err)
+	}
+
+	w := NewWorker(&DaemonConfig{MaxRunning: maxRunning}, &stubFetcher{})
+	w.shared = &sharedClient{langs: manager}
+	return w
+}
+
+func TestFitsRespectsWeightAndLanguageLimit(t *testing.T) {
+	w := newSlotsTestWorker(t, 4)
+	java := Job{SolutionID: 1, Language: 3}
+	c := Job{SolutionID: 2, Language: 0}
+
+	if !w.fits(java) {
+		t.Fatal("java does not fit on an idle judger")
+	}
+	w.running[0] = &runningJob{solutionID: 1, language: 3, weight: w.langWeight(3)}
+	if got := w.usedSlots(); got != 2 {
+		t.Errorf("usedSlots = %d; want 2", got)
+	}
+
+	if w.fits(Job{SolutionID: 3, Language: 3}) {
+		t.Error("second java run fits despite max_running = 1")
+	}
+	if !w.fits(c) {
+		t.Error("C run does not fit next to one java run")
+	}
+
+	w.running[1] = &runningJob{solutionID: 2, language: 0, weight: 1}
+	w.running[2] = &runningJob{solutionID: 4, language: 0, weight: 1}
+	if w.fits(c) {
+		t.Error("C run fits although all slots are taken")
+	}
+}
+
+func TestHeavyLanguageRunsOnIdleJudger(t *testing.T) {
+	w := newSlotsTestWorker(t, 1)
+
+	if got := w.langWeight(3); got != 1 {
+		t.Errorf("langWeight = %d; want it clamped to OJ_RUNNING", got)
+	}
+	if !w.fits(Job{SolutionID: 1, Language: 3}) {
+		t.Error("java does not fit on an idle single-slot judger")
+	}
+}
+
+func TestHeavyJobIsNotStarved(t *testing.T) {
+	w := newSlotsTestWorker(t, 2)
+	w.cfg.StarveLimit = 2
+	java := Job{SolutionID: 1, Language: 3}
+	w.queue.jobs = []Job{java}
+	start := func(clientID int, job Job) {
+		w.running[clientID] = &runningJob{solutionID: job.SolutionID, language: job.Language, weight: w.langWeight(job.Language)}
+		w.startedJob(job)
+	}
+
+	// A steady stream of C runs keeps one of the two slots busy.
+	w.running[0] = &runningJob{solutionID: 10, language: 0, weight: 1}
+	if w.fits(java) {
+		t.Fatal("java fits next to a C run on two slots")
+	}
+	w.tooHeavy(java)
+	for i, clientID := range []int{1, 0} {
+		c := Job{SolutionID: 11 + i, Language: 0}
+		if w.holds(c) {
+			t.Fatalf("C run %d held back before OJ_PRIORITY_STARVATION runs passed the java run", i+1)
+		}
+		delete(w.running, clientID^1) // The other C run finishes
+		start(clientID, c)
+	}
+
+	if !w.holds(Job{SolutionID: 13, Language: 0}) {
+		t.Fatal("C runs keep starting ahead of the starved java run")
+	}
+	delete(w.running, 0)
+	if !w.fits(java) {
+		t.Fatal("java does not fit on the idle judger")
+	}
+	start(0, java)
+	if w.holds(Job{SolutionID: 13, Language: 0}) {
+		t.Error("C runs still held back after the java run started")
+	}
+}
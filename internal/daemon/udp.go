@@ -0,0 +1,53 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+)
+
+// udpWaker listens for the packet the web frontend sends on every submit
+// and wakes the workers instead of letting them sleep until the next poll.
+// The packet does not say which site it came from, so all sites are woken.
+type udpWaker struct {
+	conn net.PacketConn
+	wake []chan<- struct{}
+}
+
+func newUDPWaker(addr string, wake ...chan<- struct{}) (*udpWaker, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not listen on udp %s: %w", addr, err)
+	}
+	return &udpWaker{conn: conn, wake: wake}, nil
+}
+
+// serve reads packets until ctx is cancelled. The packet content is ignored:
+// any packet means "there is something to judge". Bursts coalesce because
+// wake holds at most one pending signal.
+func (u *udpWaker) serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		u.conn.Close()
+	}()
+
+	buf := make([]byte, 512)
+	for {
+		_, _, err := u.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("UDP wake-up read failed", "err", err)
+			continue
+		}
+		for _, wake := range u.wake {
+			select {
+			case wake <- struct{}{}:
+			default: // A wake-up is already pending
+			}
+		}
+	}
+}
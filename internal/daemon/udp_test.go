@@ -0,0 +1,75 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUDPWakerCoalescesBurst(t *testing.T) {
+	wake := make(chan struct{}, 1)
+	waker, err := newUDPWaker("127.0.0.1:0", wake)
+	if err != nil {
+		t.Fatalf("newUDPWaker: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go waker.serve(ctx)
+
+	conn, err := net.Dial("udp", waker.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 10; i++ {
+		if _, err := conn.Write([]byte("1001")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	select {
+	case <-wake:
+	case <-time.After(time.Second):
+		t.Fatal("no wake-up received")
+	}
+
+	// Give the listener time to drain the rest of the burst.
+	time.Sleep(50 * time.Millisecond)
+	pending := 0
+	for {
+		select {
+		case <-wake:
+			pending++
+			continue
+		default:
+		}
+		break
+	}
+	if pending > 1 {
+		t.Errorf("burst produced %d extra wake-ups; want at most 1", pending)
+	}
+}
+
+func TestUDPWakerStopsOnCancel(t *testing.T) {
+	waker, err := newUDPWaker("127.0.0.1:0", make(chan struct{}, 1))
+	if err != nil {
+		t.Fatalf("newUDPWaker: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		waker.serve(ctx)
+		close(stopped)
+	}()
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("serve did not return after cancel")
+	}
+}
@@ -0,0 +1,138 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+)
+
+const (
+	// watchdogCompileAllowance covers setup, compilation and the final
+	// database writes of a judgement.
+	watchdogCompileAllowance = 60 * time.Second
+	// watchdogTestOverhead is added to the real-time limit of every test
+	// for mounting, comparing output and running the special judge.
+	watchdogTestOverhead = 2 * time.Second
+)
+
+// judgeTimeout returns how long the judgement of solutionID may occupy a
+// client slot. The limit is derived from the problem's time limit and test
+// count and never exceeds OJ_JUDGE_TIMEOUT. Zero disables the watchdog.
+func judgeTimeout(cfg *DaemonConfig, db interfaces.Database, solutionID int) time.Duration {
+	limit := time.Duration(cfg.JudgeTimeout) * time.Second
+	if limit <= 0 || db == nil {
+		return limit
+	}
+
+	derived, err := derivedJudgeTimeout(cfg.OJHome, db, solutionID)
+	if err != nil {
+		slog.Warn("Could not derive judge timeout, using the global cap", "solution_id", solutionID, "err", err)
+		return limit
+	}
+	return min(derived, limit)
+}
+
+func derivedJudgeTimeout(ojHome string, db interfaces.Database, solutionID int) (time.Duration, error) {
+	solution, err := db.GetSolution(solutionID)
+	if err != nil {
+		return 0, err
+	}
+	problem, err := db.GetProblem(solution.ProblemID)
+	if err != nil {
+		return 0, err
+	}
+
+	dataDir := filepath.Join(ojHome, "data", strconv.Itoa(solution.ProblemID))
+	tests, err := filepath.Glob(filepath.Join(dataDir, "*.in"))
+	if err != nil {
+		return 0, err
+	}
+
+	// The sandbox allows three times the CPU limit in real time.
+	perTest := time.Duration(problem.TimeLimit*3*float64(time.Second)) + watchdogTestOverhead
+	return watchdogCompileAllowance + time.Duration(max(len(tests), 1))*perTest, nil
+}
+
+// recordTimeout cleans up after a judgement killed by the watchdog and marks
+// the solution as a system error, so it is not left in "judging" forever.
+func (w *Worker) recordTimeout(clientID int, job *runningJob, output string) {
+	slog.Error("Judgement exceeded its wall time limit, killed",
+		"solution_id", job.solutionID, "client_id", clientID, "limit", job.limit())
+
+	w.cleanupRunner(clientID, job.solutionID)
+	details := fmt.Sprintf("System Error: judging took longer than %s and was stopped by judger %s (client %d).\n",
+		job.limit(), w.cfg.Judger, clientID)
+	if output != "" {
+		details += "\nClient output:\n" + output
+	}
+	w.recordSystemError(job, details)
+}
+
+// armWatchdog derives the wall time limit of job and cancels its context
+// once the limit passes.
+func (job *runningJob) armWatchdog(cfg *DaemonConfig, db interfaces.Database) {
+	job.watch(judgeTimeout(cfg, db, job.solutionID))
+}
+
+// watch cancels the context of job once limit has passed since it started.
+func (job *runningJob) watch(limit time.Duration) {
+	if limit <= 0 {
+		return
+	}
+	job.timeout.Store(int64(limit))
+	timer := time.AfterFunc(limit-time.Since(job.started), func() {
+		job.timedOut.Store(true)
+		job.cancel()
+	})
+	context.AfterFunc(job.ctx, func() { timer.Stop() })
+}
+
+// overdue reports whether the client of job should have exited by now: it
+// was killed, by the watchdog or an operator, more than killGrace ago.
+func (job *runningJob) overdue(now time.Time) bool {
+	if !job.killedAt.IsZero() && now.Sub(job.killedAt) > killGrace {
+		return true
+	}
+	limit := job.limit()
+	return limit > 0 && now.Sub(job.started) > limit+killGrace
+}
+
+// reapLost frees the slots of clients that did not exit after they were
+// killed, such as an in-process client stuck in an unmount or a database
+// call, or a subprocess in uninterruptible sleep. The solution is marked as
+// a system error. The client id stays taken until the client exits, since
+// it may still use its work directory.
+func (w *Worker) reapLost() {
+	now := time.Now()
+	for clientID, job := range w.running {
+		if !job.overdue(now) {
+			continue
+		}
+		slog.Error("Client did not exit after it was killed, freeing its slot",
+			"solution_id", job.solutionID, "client_id", clientID, "running_for", now.Sub(job.started).Round(time.Second))
+		delete(w.running, clientID)
+		w.lost[clientID] = job
+		if w.pool != nil {
+			w.pool.lose(clientID)
+		}
+		w.metrics.runningSlots.Set(float64(len(w.running)))
+		w.recordSystemError(job, fmt.Sprintf(
+			"System Error: the judge client on %s did not exit after it was stopped (client %d).\n",
+			w.cfg.Judger, clientID))
+		w.ack(job.solutionID)
+	}
+}
+
+// finishLost cleans up after a lost client that exited at last and hands
+// its client id back.
+func (w *Worker) finishLost(clientID int, job *runningJob) {
+	slog.Info("Lost client exited", "solution_id", job.solutionID, "client_id", clientID)
+	delete(w.lost, clientID)
+	w.cleanupRunner(clientID, job.solutionID)
+	w.releaseSlot(clientID)
+}
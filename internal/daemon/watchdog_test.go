@@ -0,0 +1,183 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+)
+
+// stubDB serves a fixed problem and records verdicts.
+type stubDB struct {
+	mu          sync.Mutex
+	problem     interfaces.Problem
+	results     map[int]int
+	runtimeInfo map[int]string
+	lookups     int // GetSolution calls
+}
+
+func newStubDB(problem interfaces.Problem) *stubDB {
+	return &stubDB{problem: problem, results: map[int]int{}, runtimeInfo: map[int]string{}}
+}
+
+func (d *stubDB) GetSolution(solutionID int) (*interfaces.Solution, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lookups++
+	return &interfaces.Solution{ID: solutionID, ProblemID: d.problem.ID, Result: d.results[solutionID]}, nil
+}
+
+func (d *stubDB) GetProblem(problemID int) (*interfaces.Problem, error) {
+	p := d.problem
+	return &p, nil
+}
+
+func (d *stubDB) GetSolutionSource(solutionID int) (string, error) { return "", nil }
+
+func (d *stubDB) UpdateSolution(solutionID, result, timeUsed, memoryUsed int, passRate float64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.results[solutionID] = result
+	return nil
+}
+
+func (d *stubDB) UpdateUserStats(userID string) error                  { return nil }
+func (d *stubDB) UpdateProblemStats(problemID, contestID int) error    { return nil }
+func (d *stubDB) AddCompileError(solutionID int, message string) error { return nil }
+
+func (d *stubDB) AddRuntimeInfo(solutionID int, details string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.runtimeInfo[solutionID] = details
+	return nil
+}
+
+func (d *stubDB) Close() error { return nil }
+
+func TestJudgeTimeoutFromProblemLimits(t *testing.T) {
+	home := t.TempDir()
+	dataDir := filepath.Join(home, "data", "1000")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"1.in", "1.out", "2.in", "2.out", "3.in"} {
+		if err := os.WriteFile(filepath.Join(dataDir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := newStubDB(interfaces.Problem{ID: 1000, TimeLimit: 1})
+	cfg := &DaemonConfig{JudgeConfig: &config.JudgeConfig{OJHome: home}, JudgeTimeout: 1800}
+
+	// 60s allowance + 3 tests * (3 * 1s + 2s)
+	if got, want := judgeTimeout(cfg, db, 1), 75*time.Second; got != want {
+		t.Errorf("judgeTimeout = %v; want %v", got, want)
+	}
+
+	cfg.JudgeTimeout = 30
+	if got, want := judgeTimeout(cfg, db, 1), 30*time.Second; got != want {
+		t.Errorf("judgeTimeout with cap = %v; want %v", got, want)
+	}
+
+	cfg.JudgeTimeout = 0
+	if got := judgeTimeout(cfg, db, 1); got != 0 {
+		t.Errorf("judgeTimeout with watchdog disabled = %v; want 0", got)
+	}
+}
+
+func TestWatchdogRecordsSystemError(t *testing.T) {
+	f := &stubFetcher{}
+	db := newStubDB(interfaces.Problem{ID: 1000, TimeLimit: 1})
+	cfg := &DaemonConfig{
+		JudgeConfig: &config.JudgeConfig{OJHome: t.TempDir(), Judger: "j1"},
+		MaxRunning:  1,
+	}
+	w := NewWorker(cfg, f)
+	w.shared = &sharedClient{db: db}
+
+	// A hung client that only exits when it is killed.
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &runningJob{solutionID: 500, ctx: ctx, cancel: cancel, started: time.Now()}
+	job.watch(20 * time.Millisecond)
+	w.running[0] = job
+	go func() {
+		<-ctx.Done()
+		w.done <- jobResult{clientID: 0}
+	}()
+
+	select {
+	case res := <-w.done:
+		w.finish(res)
+	case <-time.After(time.Second):
+		t.Fatal("hung job was not killed")
+	}
+
+	if db.results[500] != OJ_SE {
+		t.Errorf("result = %d; want OJ_SE", db.results[500])
+	}
+	if !strings.Contains(db.runtimeInfo[500], "j1") {
+		t.Errorf("runtime info %q does not name the judger", db.runtimeInfo[500])
+	}
+	if len(w.running) != 0 {
+		t.Error("slot was not released")
+	}
+	if len(f.acked) != 1 {
+		t.Errorf("acked = %v; timed out job must be acknowledged", f.acked)
+	}
+}
+
+func TestLostClientFreesItsSlot(t *testing.T) {
+	f := &stubFetcher{}
+	db := newStubDB(interfaces.Problem{ID: 1000, TimeLimit: 1})
+	cfg := &DaemonConfig{
+		JudgeConfig: &config.JudgeConfig{OJHome: t.TempDir(), Judger: "j1"},
+		MaxRunning:  1,
+	}
+	w := NewWorker(cfg, f)
+	w.shared = &sharedClient{db: db}
+
+	// Killed by an operator, but stuck and never sending on done.
+	w.running[0] = &runningJob{solutionID: 700, cancel: func() {}, started: time.Now(),
+		killed: true, killedAt: time.Now().Add(-killGrace - time.Second)}
+	w.reapLost()
+
+	if len(w.running) != 0 || w.usedSlots() != 0 {
+		t.Errorf("running = %v; want the slot freed", w.running)
+	}
+	if db.results[700] != OJ_SE || len(f.acked) != 1 {
+		t.Errorf("result = %d, acked = %v; want OJ_SE and an ack", db.results[700], f.acked)
+	}
+	if id := w.takeSlot(Job{SolutionID: 701}, 0); id != 1 {
+		t.Errorf("takeSlot = %d; want 1, client 0 may still use its work directory", id)
+	}
+
+	// The client exits at last.
+	w.finish(jobResult{clientID: 0})
+	if len(w.lost) != 0 {
+		t.Errorf("lost = %v after the client exited", w.lost)
+	}
+	if id := w.takeSlot(Job{SolutionID: 701}, 0); id != 0 {
+		t.Errorf("takeSlot = %d; want client 0 back", id)
+	}
+	if len(f.acked) != 1 {
+		t.Errorf("acked = %v; the late exit must not acknowledge again", f.acked)
+	}
+}
+
+func TestLostClientInPool(t *testing.T) {
+	p := newSlotPool(1)
+	p.add("school", 1)
+	id := p.acquire("school", 1, 0)
+	p.lose(id)
+	if !p.idle() {
+		t.Error("pool not idle with only a lost client")
+	}
+	if next := p.acquire("school", 1, 0); next == -1 || next == id {
+		t.Errorf("acquire = %d; want a free slot other than the lost client %d", next, id)
+	}
+}
@@ -3,113 +3,511 @@ package daemon
 import (
 	"context"
 	"log/slog"
+	"slices"
+	"sync/atomic"
 	"time"
+
+	"github.com/sempr/hustoj-go/pkg/backoff"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+	"github.com/sempr/hustoj-go/pkg/language"
+	"github.com/sempr/hustoj-go/pkg/models"
 )
 
 const (
-	OJ_CI = 2 // Compiling & Judging
+	OJ_WT1 = 1  // Rejudge waiting
+	OJ_CI  = 2  // Compiling & Judging
+	OJ_AC  = 4  // Accepted, the first final verdict
+	OJ_SE  = 99 // System Error
 )
 
+// killGrace bounds how long drain waits for killed clients to exit.
+const killGrace = 10 * time.Second
+
+// Failed fetches are retried after exponentially growing delays within
+// these bounds, instead of every poll.
+const (
+	fetchBackoffBase = time.Second
+	fetchBackoffMax  = time.Minute
+)
+
+// runningJob tracks a judgement occupying a client slot.
+type runningJob struct {
+	solutionID int
+	userID     string
+	language   int
+	weight     int // Slots taken, see usedSlots
+	ctx        context.Context
+	cancel     context.CancelFunc
+	started    time.Time
+	timeout    atomic.Int64 // Watchdog limit in nanoseconds, 0 if none or not derived yet
+	timedOut   atomic.Bool  // Cancelled by the watchdog
+	killed     bool         // Stopped with `hustoj-go ctl kill`
+	killedAt   time.Time    // When it was stopped with `hustoj-go ctl kill`
+	memory     int          // MB reserved by memory admission, 0 if disabled
+}
+
+// limit returns the watchdog limit of the judgement, 0 if none.
+func (job *runningJob) limit() time.Duration {
+	return time.Duration(job.timeout.Load())
+}
+
 // Worker manages the cycle of fetching and running jobs.
 type Worker struct {
-	cfg     *DaemonConfig
-	fetcher JobFetcher
-	done    chan int    // Channel to receive client IDs of finished jobs
-	running map[int]int // Maps clientID to solutionID
+	cfg        *DaemonConfig
+	site       string // Section of judge.conf the worker serves, empty for a single site
+	fetcher    JobFetcher
+	done       chan jobResult             // Channel to receive results of finished jobs
+	wake       chan struct{}              // Signalled when new submissions may be pending
+	ctl        chan ctlCall               // Requests from the control socket
+	reloads    chan *DaemonConfig         // Configurations re-read on SIGHUP
+	running    map[int]*runningJob        // Maps clientID to the job in that slot
+	lost       map[int]*runningJob        // Killed jobs whose clients never exited, by clientID
+	attempts   map[int]int                // Crashed attempts per solution
+	queue      jobQueue                   // Prefetched pending jobs
+	policy     PriorityPolicy             // Order in which pending jobs are started
+	sched      map[int]language.SchedInfo // Cached [sched] tables by language
+	shared     *sharedClient              // Database and languages shared by the daemon
+	pool       *slotPool                  // Slots shared with the other sites, nil for a single site
+	freed      <-chan struct{}            // Signalled when another site releases slots
+	breaker    langBreaker                // Languages disabled after repeated sandbox system errors
+	heavy      heavyGuard                 // Heavy job waiting for enough free slots
+	metrics    *metrics
+	health     *health         // Reachability of the job queue
+	backoff    backoff.Backoff // Delays between failed fetches
+	retryAt    time.Time       // No fetch before this time after a failure
+	started    time.Time
+	paused     bool // No new jobs are started
+	draining   bool // Paused, and Run returns once nothing is running
+	overloaded bool // Load average above OJ_LOAD_LIMIT at the last check
 }
 
 func NewWorker(cfg *DaemonConfig, fetcher JobFetcher) *Worker {
+	site := ""
+	if cfg.JudgeConfig != nil {
+		site = cfg.Site
+	}
 	return &Worker{
-		cfg:     cfg,
-		fetcher: fetcher,
-		done:    make(chan int, cfg.MaxRunning),
-		running: make(map[int]int),
+		cfg:      cfg,
+		site:     site,
+		fetcher:  fetcher,
+		done:     make(chan jobResult, cfg.MaxRunning),
+		wake:     make(chan struct{}, 1),
+		ctl:      make(chan ctlCall),
+		reloads:  make(chan *DaemonConfig, 1),
+		running:  make(map[int]*runningJob),
+		lost:     make(map[int]*runningJob),
+		queue:    jobQueue{stale: true},
+		attempts: make(map[int]int),
+		policy:   newPriorityPolicy(cfg),
+		sched:    make(map[int]language.SchedInfo),
+		breaker:  newLangBreaker(),
+		metrics:  newMetrics(cfg, fetcher),
+		health:   &health{},
+		backoff:  backoff.Backoff{Base: fetchBackoffBase, Max: fetchBackoffMax},
 	}
 }
 
-// Run starts the main worker loop.
+// Run starts the main worker loop. Jobs are started whenever a slot frees
+// up, from a local queue that is refilled when it runs low, every
+// SleepTime seconds, and on UDP wake-ups.
 func (w *Worker) Run(ctx context.Context) {
+	w.started = time.Now()
+	var stopProbes context.CancelFunc
+	w.breaker.ctx, stopProbes = context.WithCancel(ctx)
+	defer stopProbes()
 	ticker := time.NewTicker(time.Duration(w.cfg.SleepTime) * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
+			w.drain()
 			return
 		default:
-			jobsProcessed := w.work()
+		}
 
-			// If in 'once' mode and nothing was processed, exit.
-			if w.cfg.Once && jobsProcessed == 0 {
-				return
-			}
+		jobsProcessed := w.work()
+		if jobsProcessed > 0 {
+			continue // Fill the remaining slots before waiting
+		}
 
-			// If there were no jobs, wait before trying again.
-			if jobsProcessed == 0 {
-				slog.Debug("Sleeping", "duration_sec", w.cfg.SleepTime)
-				<-ticker.C
-			}
+		// In 'once' mode, exit when nothing is pending or running.
+		if w.cfg.Once && len(w.running) == 0 {
+			return
+		}
+		if w.draining && len(w.running) == 0 {
+			slog.Info("Drained by control command")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			w.drain()
+			return
+		case res := <-w.done:
+			w.finish(res)
+		case <-w.wake:
+			slog.Debug("Woken up by UDP")
+			w.queue.stale = true
+		case <-ticker.C:
+			w.queue.stale = true
+		case <-w.retryTimer():
+		case <-w.freed:
+		case langID := <-w.breaker.probes:
+			w.enableLanguage(langID)
+		case c := <-w.ctl:
+			c.reply <- w.handleCtl(c.req)
+		case next := <-w.reloads:
+			w.reload(next)
+			ticker.Reset(time.Duration(w.cfg.SleepTime) * time.Second)
 		}
 	}
 }
 
-// work performs a single iteration of fetching and assigning jobs.
+// retryTimer fires when the backoff after a failed fetch has passed. It
+// returns nil, which blocks forever, when no retry is pending.
+func (w *Worker) retryTimer() <-chan time.Time {
+	if w.retryAt.IsZero() {
+		return nil
+	}
+	return time.After(time.Until(w.retryAt))
+}
+
+// work starts as many queued jobs as the free slots allow, refilling the
+// queue first if needed. It returns the number of jobs started.
 func (w *Worker) work() int {
 	// Clean up finished jobs
 	w.cleanupFinishedJobs()
+	w.reapLost()
 
-	// Get new jobs
-	jobs, err := w.fetcher.GetJobs(w.cfg.MaxRunning)
-	if err != nil {
-		slog.Error("Could not get jobs", "err", err)
+	waiting := false // Jobs were held back for slots of other sites
+	if w.pool != nil {
+		defer func() { w.pool.setWaiting(w.site, waiting) }()
+	}
+
+	if w.paused {
 		return 0
 	}
-	if len(jobs) == 0 && len(w.running) == 0 {
+	if w.usedSlots() >= w.cfg.MaxRunning {
+		return 0 // No available slots
+	}
+	if w.loadTooHigh() {
+		return 0
+	}
+
+	if w.queue.needsFetch(w.cfg.MaxRunning) && !time.Now().Before(w.retryAt) {
+		w.refill()
+	}
+	if len(w.queue.jobs) == 0 {
 		return 0
 	}
 
 	jobCount := 0
-	// Assign new jobs
-	for _, solutionID := range jobs {
-		if len(w.running) >= w.cfg.MaxRunning {
+	mem := w.memoryBudget()
+	// Assign queued jobs
+	for _, pending := range w.policy.Order(w.queue.jobs) {
+		if w.usedSlots() >= w.cfg.MaxRunning {
 			break // No available slots
 		}
-
-		// Find a free clientID
-		clientID := -1
-		for i := 0; i < w.cfg.MaxRunning; i++ {
-			if _, exists := w.running[i]; !exists {
-				clientID = i
-				break
+		solutionID := pending.SolutionID
+		if w.breaker.isDisabled(pending.Language) {
+			continue // Left in the queue until the language works again
+		}
+		if w.cfg.UserSlots > 0 && pending.UserID != "" && w.userSlots(pending.UserID) >= w.cfg.UserSlots {
+			continue // This user already holds enough slots
+		}
+		if w.holds(pending) {
+			continue // Slots are left to free up for a heavy job
+		}
+		if !w.fits(pending) {
+			if w.usedSlots()+w.langWeight(pending.Language) > w.cfg.MaxRunning {
+				w.tooHeavy(pending)
 			}
+			continue // Too heavy for the free slots, try a lighter job
 		}
-
-		if clientID != -1 {
-			ok, err := w.fetcher.CheckOut(solutionID, OJ_CI)
-			if err != nil {
-				slog.Error("Checkout failed for solution", "solution_id", solutionID, "err", err)
+		need := 0
+		if mem != nil {
+			need = w.jobMemory(&pending)
+			if !mem.admit(need, w.idle()) {
+				slog.Debug("Not enough memory for solution", "solution_id", solutionID, "need_mb", need, "available_mb", mem.available)
 				continue
 			}
-			if ok {
-				slog.Info("Starting judgment", "solution_id", solutionID, "client_id", clientID)
-				w.running[clientID] = solutionID
-				go RunClient(w.cfg, solutionID, clientID, w.done)
-				jobCount++
-			}
 		}
+
+		clientID := w.takeSlot(pending, need)
+		if clientID == -1 {
+			waiting = w.pool != nil
+			continue
+		}
+
+		ok, err := w.fetcher.CheckOut(solutionID, OJ_CI)
+		if err != nil {
+			w.releaseSlot(clientID)
+			w.metrics.fetcherErrors.Inc()
+			slog.Error("Checkout failed for solution", "solution_id", solutionID, "err", err)
+			continue
+		}
+		w.queue.remove(solutionID) // Started, or taken by another judger
+		if !ok {
+			w.releaseSlot(clientID)
+			continue
+		}
+		w.policy.Started(pending)
+		w.startedJob(pending)
+		slog.Info("Starting judgment", "solution_id", solutionID, "client_id", clientID)
+		job := w.newJob(pending)
+		job.memory = need
+		w.running[clientID] = job
+		w.metrics.runningSlots.Set(float64(len(w.running)))
+		w.startClient(job, clientID)
+		jobCount++
 	}
 	return jobCount
 }
 
+// refill replaces the local queue with the pending jobs of the fetcher.
+// Look further than the free slots so the priority policy has something
+// to choose from.
+func (w *Worker) refill() {
+	ids, err := w.fetcher.GetJobs(prefetchMultiplier * w.cfg.MaxRunning)
+	if err != nil {
+		w.metrics.fetcherErrors.Inc()
+		w.metrics.backendUp.Set(0)
+		delay := w.backoff.Next()
+		w.retryAt = time.Now().Add(delay)
+		failures := w.health.fail(err)
+		slog.Error("Could not get jobs, backing off", "err", err, "failures", failures, "retry_in", delay.Round(time.Millisecond))
+		return
+	}
+	if outage := w.health.ok(); outage > 0 {
+		slog.Info("Job queue reachable again", "outage", outage.Round(time.Second))
+	}
+	w.metrics.backendUp.Set(1)
+	w.backoff.Reset()
+	w.retryAt = time.Time{}
+	var jobs []Job
+	if len(ids) > 0 {
+		jobs = w.describe(ids)
+	}
+	w.queue.replace(jobs, w.isRunning)
+}
+
+// takeSlot returns a free client id for pending, taken from the pool shared
+// with the other sites if there is one, or -1 if there is none.
+func (w *Worker) takeSlot(pending Job, memory int) int {
+	if w.pool != nil {
+		return w.pool.acquire(w.site, w.langWeight(pending.Language), memory)
+	}
+	// Lost clients may still use their work directories, so their ids are
+	// skipped until they exit.
+	for i := 0; i < w.cfg.MaxRunning+len(w.lost); i++ {
+		if _, exists := w.running[i]; !exists && w.lost[i] == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// releaseSlot returns the client id of a finished job to the pool.
+func (w *Worker) releaseSlot(clientID int) {
+	if w.pool != nil {
+		w.pool.release(clientID)
+	}
+}
+
+// joinPool makes the worker share its slots with the other sites of pool.
+func (w *Worker) joinPool(pool *slotPool) {
+	w.pool = pool
+	w.freed = pool.add(w.site, w.cfg.SiteWeight)
+}
+
+// idle reports whether nothing is judging, on any site.
+func (w *Worker) idle() bool {
+	return len(w.running) == 0 && (w.pool == nil || w.pool.idle())
+}
+
+// isRunning reports whether solutionID occupies a slot.
+func (w *Worker) isRunning(solutionID int) bool {
+	for _, job := range w.running {
+		if job.solutionID == solutionID {
+			return true
+		}
+	}
+	return false
+}
+
+// newJob sets up the context of a judgement, which is cancelled to kill the
+// client.
+func (w *Worker) newJob(pending Job) *runningJob {
+	job := &runningJob{
+		solutionID: pending.SolutionID,
+		userID:     pending.UserID,
+		language:   pending.Language,
+		weight:     w.langWeight(pending.Language),
+		started:    time.Now(),
+	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	return job
+}
+
+// startClient judges job on clientID in the background. The watchdog limit
+// needs the database and the test files, so it is derived there too rather
+// than in the worker loop.
+func (w *Worker) startClient(job *runningJob, clientID int) {
+	cfg := *w.cfg // The worker changes its own copy on reload
+	var db interfaces.Database
+	if w.shared != nil {
+		db = w.shared.db
+	}
+	shared := w.shared
+	go func() {
+		job.armWatchdog(&cfg, db)
+		if cfg.InternalClient && shared != nil {
+			RunInternalClient(job.ctx, &cfg, shared, job.solutionID, clientID, w.done)
+		} else {
+			RunClient(job.ctx, &cfg, job.solutionID, clientID, w.done)
+		}
+	}()
+}
+
 func (w *Worker) cleanupFinishedJobs() {
 	for {
 		select {
-		case clientID := <-w.done:
-			solutionID := w.running[clientID]
-			slog.Info("Judgment finished", "solution_id", solutionID, "client_id", clientID)
-			delete(w.running, clientID)
+		case res := <-w.done:
+			w.finish(res)
 		default:
 			return // No more finished jobs
 		}
 	}
 }
+
+// finish releases the slot of a job whose client has exited.
+func (w *Worker) finish(res jobResult) {
+	clientID := res.clientID
+	if job := w.lost[clientID]; job != nil {
+		w.finishLost(clientID, job)
+		return
+	}
+	job := w.running[clientID]
+	if job == nil {
+		return
+	}
+	slog.Info("Judgment finished", "solution_id", job.solutionID, "client_id", clientID)
+	timedOut := job.timedOut.Load()
+	job.cancel()
+	delete(w.running, clientID)
+	w.releaseSlot(clientID)
+	w.metrics.runningSlots.Set(float64(len(w.running)))
+
+	ack := true
+	switch {
+	case timedOut:
+		w.recordTimeout(clientID, job, res.output)
+		res.report = systemErrorReport(res.report)
+	case job.killed:
+		w.recordKilled(clientID, job, res.output)
+		res.report = systemErrorReport(res.report)
+	case !w.judged(job.solutionID, res.report):
+		if ack = w.handleCrash(clientID, job, res.output); ack {
+			res.report = systemErrorReport(res.report)
+		}
+	default:
+		delete(w.attempts, job.solutionID)
+	}
+	if !timedOut && !job.killed {
+		w.observeLanguage(job, res.report)
+	}
+	w.metrics.observe(res.report, time.Since(job.started))
+	if ack {
+		w.ack(job.solutionID)
+	}
+}
+
+// ack tells fetchers that track checked-out jobs that solutionID is done.
+func (w *Worker) ack(solutionID int) {
+	if acker, ok := w.fetcher.(JobAcker); ok {
+		if err := acker.Ack(solutionID); err != nil {
+			slog.Warn("Could not acknowledge job", "solution_id", solutionID, "err", err)
+		}
+	}
+}
+
+// systemErrorReport marks report, or a new one, with the OJ_SE verdict the
+// daemon wrote on behalf of the client.
+func systemErrorReport(report *models.JudgeReport) *models.JudgeReport {
+	if report == nil {
+		report = &models.JudgeReport{}
+	}
+	report.Result = OJ_SE
+	return report
+}
+
+// drain stops taking new jobs and waits up to DrainTimeout for running
+// judgements. Whatever is still running after that is killed and handed
+// back to the queue so another judger picks it up.
+func (w *Worker) drain() {
+	if len(w.running) == 0 {
+		return
+	}
+	slog.Info("Draining running judgements", "running", len(w.running), "timeout_sec", w.cfg.DrainTimeout)
+
+	deadline := time.NewTimer(time.Duration(w.cfg.DrainTimeout) * time.Second)
+	defer deadline.Stop()
+	for len(w.running) > 0 {
+		select {
+		case res := <-w.done:
+			w.finish(res)
+		case c := <-w.ctl:
+			c.reply <- w.handleCtl(c.req)
+		case <-deadline.C:
+			w.killAndRequeue()
+			return
+		}
+	}
+	slog.Info("All judgements finished")
+}
+
+func (w *Worker) killAndRequeue() {
+	var killed []int
+	for clientID, job := range w.running {
+		slog.Warn("Drain timeout, killing judgement", "solution_id", job.solutionID, "client_id", clientID)
+		job.cancel()
+		killed = append(killed, job.solutionID)
+	}
+
+	grace := time.NewTimer(killGrace)
+	defer grace.Stop()
+	for len(w.running) > 0 {
+		select {
+		case res := <-w.done:
+			// Not acknowledged: the job goes back to the queue below,
+			// unless its client still sent the final verdict.
+			if job := w.running[res.clientID]; job != nil && res.report != nil && res.report.Result >= OJ_AC {
+				killed = slices.DeleteFunc(killed, func(id int) bool { return id == job.solutionID })
+				w.ack(job.solutionID)
+			}
+			delete(w.running, res.clientID)
+			w.releaseSlot(res.clientID)
+		case <-grace.C:
+			slog.Error("Clients did not exit after kill", "running", len(w.running))
+			for clientID := range w.running {
+				w.releaseSlot(clientID)
+			}
+			w.running = make(map[int]*runningJob)
+		}
+	}
+	w.metrics.runningSlots.Set(0)
+
+	requeuer, ok := w.fetcher.(JobRequeuer)
+	if !ok {
+		slog.Warn("Fetcher cannot requeue jobs, leaving them for manual recovery", "solution_ids", killed)
+		return
+	}
+	for _, solutionID := range killed {
+		if err := requeuer.Requeue(solutionID); err != nil {
+			slog.Error("Could not requeue solution", "solution_id", solutionID, "err", err)
+			continue
+		}
+		slog.Info("Requeued solution", "solution_id", solutionID)
+	}
+}
@@ -0,0 +1,134 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/models"
+)
+
+// stubFetcher records acknowledgements and requeues.
+type stubFetcher struct {
+	mu       sync.Mutex
+	jobs     []int
+	fetches  int
+	acked    []int
+	requeued []int
+}
+
+func (f *stubFetcher) GetJobs(maxJobs int) ([]int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fetches++
+	jobs := f.jobs
+	f.jobs = nil
+	return jobs, nil
+}
+
+func (f *stubFetcher) CheckOut(solutionID int, result int) (bool, error) { return true, nil }
+func (f *stubFetcher) Close() error                                      { return nil }
+
+func (f *stubFetcher) Ack(solutionID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acked = append(f.acked, solutionID)
+	return nil
+}
+
+func (f *stubFetcher) Requeue(solutionID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requeued = append(f.requeued, solutionID)
+	return nil
+}
+
+// startFakeJob occupies clientID with a job that finishes after d, or when
+// it is cancelled.
+func startFakeJob(w *Worker, clientID, solutionID int, d time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.running[clientID] = &runningJob{solutionID: solutionID, cancel: cancel}
+	go func() {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+		}
+		w.done <- jobResult{clientID: clientID}
+	}()
+}
+
+func TestDrainWaitsForRunningJobs(t *testing.T) {
+	f := &stubFetcher{}
+	w := NewWorker(&DaemonConfig{MaxRunning: 2, DrainTimeout: 5}, f)
+	startFakeJob(w, 0, 100, 10*time.Millisecond)
+	startFakeJob(w, 1, 101, 20*time.Millisecond)
+
+	w.drain()
+
+	if len(w.running) != 0 {
+		t.Errorf("running = %d after drain; want 0", len(w.running))
+	}
+	if len(f.acked) != 2 || len(f.requeued) != 0 {
+		t.Errorf("acked = %v, requeued = %v; want 2 acks and no requeue", f.acked, f.requeued)
+	}
+}
+
+func TestDrainKillsAndRequeuesAfterDeadline(t *testing.T) {
+	f := &stubFetcher{}
+	w := NewWorker(&DaemonConfig{MaxRunning: 2, DrainTimeout: 0}, f)
+	startFakeJob(w, 0, 200, time.Hour)
+
+	start := time.Now()
+	w.drain()
+
+	if time.Since(start) > time.Second {
+		t.Errorf("drain took %v; want prompt kill", time.Since(start))
+	}
+	if len(f.requeued) != 1 || f.requeued[0] != 200 {
+		t.Errorf("requeued = %v; want [200]", f.requeued)
+	}
+	if len(f.acked) != 0 {
+		t.Errorf("acked = %v; killed jobs must not be acknowledged", f.acked)
+	}
+}
+
+func TestDrainAcksKilledJobWithVerdict(t *testing.T) {
+	f := &stubFetcher{}
+	w := NewWorker(&DaemonConfig{MaxRunning: 2, DrainTimeout: 0}, f)
+	startFakeJob(w, 0, 200, time.Hour)
+	// This client writes its verdict while it is being killed.
+	ctx, cancel := context.WithCancel(context.Background())
+	w.running[1] = &runningJob{solutionID: 201, cancel: cancel}
+	go func() {
+		<-ctx.Done()
+		w.done <- jobResult{clientID: 1, report: &models.JudgeReport{Result: OJ_AC}}
+	}()
+
+	w.drain()
+
+	if len(f.requeued) != 1 || f.requeued[0] != 200 {
+		t.Errorf("requeued = %v; want only [200]", f.requeued)
+	}
+	if len(f.acked) != 1 || f.acked[0] != 201 {
+		t.Errorf("acked = %v; want the judged [201]", f.acked)
+	}
+}
+
+func TestRunStopsTakingJobsOnCancel(t *testing.T) {
+	f := &stubFetcher{}
+	w := NewWorker(&DaemonConfig{MaxRunning: 1, SleepTime: 1, DrainTimeout: 5}, f)
+	startFakeJob(w, 0, 300, 50*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.jobs = []int{301}
+	w.Run(ctx)
+
+	if len(f.jobs) != 1 {
+		t.Error("worker fetched new jobs after shutdown started")
+	}
+	if len(f.acked) != 1 || f.acked[0] != 300 {
+		t.Errorf("acked = %v; want [300]", f.acked)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 var ErrCgroupLimitExceeded = fmt.Errorf("cgroup CPU time limit exceeded")
@@ -87,19 +89,37 @@ func readCgroupCPUTime(statFile string) (time.Duration, error) {
 	return 0, fmt.Errorf("在 %s 中未找到 'usage_usec' 字段", statFile)
 }
 
-func setupCgroup(solutionId int, childPid int, memoryLimit int) (string, error) {
-	cgroupPath := filepath.Join("/sys/fs/cgroup", "hustoj", fmt.Sprintf("run-%d-%d", solutionId, childPid))
-	err := os.MkdirAll(cgroupPath, 0644)
-	if err != nil {
-		return "", err
+// cgroupParents returns the cgroups from the root down to the one holding
+// the runs of namespace, the daemon instance and site: /sys/fs/cgroup/hustoj
+// for the default one and /sys/fs/cgroup/hustoj/<namespace> otherwise.
+func cgroupParents(namespace string) []string {
+	dirs := []string{"/sys/fs/cgroup", filepath.Join("/sys/fs/cgroup", "hustoj")}
+	if namespace != "" {
+		dirs = append(dirs, filepath.Join("/sys/fs/cgroup", "hustoj", namespace))
 	}
+	return dirs
+}
 
-	err = os.WriteFile(filepath.Join("/sys/fs/cgroup", "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0644)
+// enableControllers enables controllers for the children of each cgroup
+// in dirs, which must be listed from the root down.
+func enableControllers(dirs []string, controllers string) error {
+	for _, dir := range dirs {
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(controllers), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setupCgroup(namespace string, solutionId int, childPid int, memoryLimit int, cpus string) (string, error) {
+	parents := cgroupParents(namespace)
+	cgroupPath := filepath.Join(parents[len(parents)-1], fmt.Sprintf("run-%d-%d", solutionId, childPid))
+	err := os.MkdirAll(cgroupPath, 0644)
 	if err != nil {
 		return "", err
 	}
-	err = os.WriteFile(filepath.Join("/sys/fs/cgroup", "hustoj", "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0644)
-	if err != nil {
+
+	if err = enableControllers(parents, "+cpu +memory +pids"); err != nil {
 		return "", err
 	}
 
@@ -115,10 +135,25 @@ func setupCgroup(solutionId int, childPid int, memoryLimit int) (string, error)
 		return "", err
 	}
 
+	if cpus != "" {
+		if err = setupCpuset(parents, cgroupPath, cpus); err != nil {
+			return "", err
+		}
+	}
+
 	err = os.WriteFile(filepath.Join(cgroupPath, "cgroup.procs"), fmt.Append(nil, childPid), 0644)
 	return cgroupPath, err
 }
 
+// setupCpuset pins the run to cpus. The cpuset controller is only enabled
+// when pinning is used, as it is not delegated on every system.
+func setupCpuset(parents []string, cgroupPath string, cpus string) error {
+	if err := enableControllers(parents, "+cpuset"); err != nil {
+		return fmt.Errorf("enable cpuset controller: %w", err)
+	}
+	return os.WriteFile(filepath.Join(cgroupPath, "cpuset.cpus"), []byte(cpus), 0644)
+}
+
 func cleanupCgroup(cgroupPath string) {
 	if strings.HasPrefix(cgroupPath, "/sys/fs/cgroup/hustoj") {
 		procs := filepath.Join(cgroupPath, "cgroup.procs")
@@ -135,3 +170,65 @@ func cleanupCgroup(cgroupPath string) {
 		}
 	}
 }
+
+// CleanupStaleCgroups kills and removes per-run cgroups of namespace left
+// behind by a crashed judgement. It must not be called while any sandbox of
+// the namespace is running.
+func CleanupStaleCgroups(namespace string) (int, error) {
+	return cleanupCgroups(namespace, "run-*")
+}
+
+// CleanupSolutionCgroups kills and removes the per-run cgroups of one
+// solution, e.g. after its judge client was killed by the watchdog.
+func CleanupSolutionCgroups(namespace string, solutionId int) (int, error) {
+	return cleanupCgroups(namespace, fmt.Sprintf("run-%d-*", solutionId))
+}
+
+func cleanupCgroups(namespace, pattern string) (int, error) {
+	parents := cgroupParents(namespace)
+	paths, err := filepath.Glob(filepath.Join(parents[len(parents)-1], pattern))
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range paths {
+		killCgroup(path)
+		if err := removeCgroup(path); err != nil {
+			slog.Warn("stale cgroup remove failed", "path", path, "error", err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// killCgroup kills every process in the cgroup, using cgroup.kill when the
+// kernel supports it.
+func killCgroup(cgroupPath string) {
+	if err := os.WriteFile(filepath.Join(cgroupPath, "cgroup.kill"), []byte("1"), 0644); err == nil {
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(cgroupPath, "cgroup.procs"))
+	if err != nil {
+		return
+	}
+	for _, pidstr := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(pidstr); err == nil {
+			unix.Kill(pid, unix.SIGKILL)
+		}
+	}
+}
+
+// removeCgroup removes an empty cgroup, waiting briefly for killed
+// processes to leave it.
+func removeCgroup(cgroupPath string) error {
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = unix.Rmdir(cgroupPath); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return err
+}
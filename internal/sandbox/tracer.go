@@ -73,7 +73,7 @@ func newTracerRunner(cfg *models.SandboxArgs, b *bytes.Buffer, childMainPid *int
 		}
 
 		memoryLimit := cfg.MemoryLimit << 10
-		*cgroupPathPtr, err = setupCgroup(cfg.SolutionId, *childMainPid, memoryLimit)
+		*cgroupPathPtr, err = setupCgroup(cfg.Instance, cfg.SolutionId, *childMainPid, memoryLimit, cfg.CPUs)
 		if err != nil {
 			panic(err)
 		}
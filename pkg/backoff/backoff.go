@@ -0,0 +1,81 @@
+// Package backoff computes retry delays that grow exponentially and are
+// spread by random jitter, so judgers that lost the same server do not
+// retry in lockstep.
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff hands out the delays of consecutive retries. The zero value is
+// not useful; set Base and Max.
+type Backoff struct {
+	Base    time.Duration // Delay ceiling of the first retry
+	Max     time.Duration // Delay ceiling of all later retries
+	attempt int
+}
+
+// Next returns the delay before the next retry: a random duration between
+// half and all of Base*2^n, capped at Max, where n counts the earlier calls
+// since Reset.
+func (b *Backoff) Next() time.Duration {
+	ceiling := b.Max
+	if b.attempt < 32 && b.Base<<b.attempt < b.Max {
+		ceiling = b.Base << b.attempt
+	}
+	b.attempt++
+	half := ceiling / 2
+	return half + rand.N(ceiling-half+1)
+}
+
+// Attempts returns the number of delays handed out since Reset.
+func (b *Backoff) Attempts() int {
+	return b.attempt
+}
+
+// Reset starts over from Base after a success.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+type permanentError struct{ err error }
+
+func (p *permanentError) Error() string { return p.err.Error() }
+func (p *permanentError) Unwrap() error { return p.err }
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// Retry calls fn up to attempts times, sleeping according to b between
+// failures. It stops early when fn succeeds, returns an error marked
+// Permanent, or ctx is done, and returns the last error of fn.
+func Retry(ctx context.Context, attempts int, b Backoff, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			t := time.NewTimer(b.Next())
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return err
+			case <-t.C:
+			}
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+		var p *permanentError
+		if errors.As(err, &p) {
+			return p.err
+		}
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNextGrowsWithinBounds(t *testing.T) {
+	b := Backoff{Base: 100 * time.Millisecond, Max: time.Second}
+	ceilings := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, c := range ceilings {
+		ceiling := c * time.Millisecond
+		if d := b.Next(); d < ceiling/2 || d > ceiling {
+			t.Errorf("delay %d = %v; want between %v and %v", i, d, ceiling/2, ceiling)
+		}
+	}
+	if b.Attempts() != len(ceilings) {
+		t.Errorf("Attempts = %d; want %d", b.Attempts(), len(ceilings))
+	}
+
+	b.Reset()
+	if d := b.Next(); d > 100*time.Millisecond {
+		t.Errorf("delay after Reset = %v; want at most the base", d)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	b := Backoff{Base: time.Millisecond, Max: time.Millisecond}
+	errDown := errors.New("down")
+
+	calls := 0
+	err := Retry(context.Background(), 5, b, func() error {
+		calls++
+		if calls < 3 {
+			return errDown
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Retry = %v after %d calls; want success after 3", err, calls)
+	}
+
+	calls = 0
+	err = Retry(context.Background(), 3, b, func() error { calls++; return errDown })
+	if err != errDown || calls != 3 {
+		t.Errorf("Retry = %v after %d calls; want errDown after 3", err, calls)
+	}
+
+	calls = 0
+	err = Retry(context.Background(), 3, b, func() error { calls++; return Permanent(errDown) })
+	if err != errDown || calls != 1 {
+		t.Errorf("Retry of permanent error = %v after %d calls; want errDown after 1", err, calls)
+	}
+}
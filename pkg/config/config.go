@@ -2,14 +2,23 @@ package config
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 )
 
+var instancePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 // DatabaseConfig holds database connection settings
 type DatabaseConfig struct {
+	Driver   string // "mysql" or "sqlite"
+	Path     string // SQLite database file, relative to OJHome
 	Host     string
 	Port     int
 	User     string
@@ -17,54 +26,190 @@ type DatabaseConfig struct {
 	Name     string
 }
 
+// HTTPConfig holds settings for judging through the web admin API
+// instead of connecting to the database directly
+type HTTPConfig struct {
+	Enable    bool
+	BaseURL   string
+	APIPath   string
+	LoginPath string
+	Username  string
+	Password  string
+}
+
+// RedisConfig holds the Redis server used by the judge clients
+type RedisConfig struct {
+	Server string
+	Port   int
+	Auth   string
+}
+
+// EventsConfig selects where judge events are published
+type EventsConfig struct {
+	Webhook string // URL events are POSTed to, empty to disable
+	Secret  string // HMAC-SHA256 key signing webhook requests
+	Retries int    // Retries of a failed webhook delivery
+	Channel string // Redis channel events are published on, empty to disable
+}
+
+// ProgressConfig selects where live judging progress is written
+type ProgressConfig struct {
+	Table    bool   // Write to the solution_progress table
+	Redis    bool   // Write to a Redis hash per solution
+	Key      string // Prefix of the Redis hashes
+	Interval int    // Minimum milliseconds between writes
+}
+
+// CPUConfig holds settings for pinning runner slots to CPU cores
+type CPUConfig struct {
+	Pin      bool     // Pin each runner slot to a dedicated CPU
+	Reserved string   // CPU list never used for judging, e.g. "0-1"
+	NoSMT    bool     // Never let two runners share a physical core
+	Confine  []string // Processes kept on the CPUs not used by runners
+}
+
 // JudgeConfig holds the main judge configuration
 type JudgeConfig struct {
 	Database DatabaseConfig
+	HTTP     HTTPConfig
+	CPU      CPUConfig
+	Redis    RedisConfig
+	Events   EventsConfig
+	Progress ProgressConfig
+	Judger   string // Identifies this judge host in shared queues and tables
+	Instance string // Separates several daemons on one host, empty for the default
+	Site     string // Section of judge.conf the configuration was read from, empty for the top
 	OJHome   string
 	Debug    bool
 	Once     bool
 }
 
+// Namespace names the run directories and cgroups of the configuration, so
+// that neither instances nor the sites of one daemon share them: the
+// instance, then the site, joined by a dot.
+func (c *JudgeConfig) Namespace() string {
+	switch {
+	case c.Site == "":
+		return c.Instance
+	case c.Instance == "":
+		return c.Site
+	}
+	return c.Instance + "." + c.Site
+}
+
+// ValidateInstance rejects instance names that are not safe in file and
+// cgroup names. Names starting with "run-" are refused too: their cgroups
+// would sit next to the per-run cgroups of the default instance, which
+// removes them as stale.
+func ValidateInstance(instance string) error {
+	if instance != "" && !instancePattern.MatchString(instance) {
+		return fmt.Errorf("invalid instance name %q: use letters, digits, '-' and '_'", instance)
+	}
+	if strings.HasPrefix(instance, "run-") {
+		return fmt.Errorf("invalid instance name %q: must not start with \"run-\"", instance)
+	}
+	return nil
+}
+
+// InstanceSuffix returns what is appended to the names of the files of an
+// instance: nothing for the default one, "-<instance>" otherwise.
+func InstanceSuffix(instance string) string {
+	if instance == "" {
+		return ""
+	}
+	return "-" + instance
+}
+
+// ConfPath returns the configuration file of instance. A named instance
+// reads etc/judge-<instance>.conf if there is one and etc/judge.conf
+// otherwise.
+func ConfPath(homePath, instance string) string {
+	if instance != "" {
+		path := filepath.Join(homePath, "etc", "judge"+InstanceSuffix(instance)+".conf")
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(homePath, "etc", "judge.conf")
+}
+
 // LoadJudgeConf loads configuration from judge.conf file
 func LoadJudgeConf(homePath string) (*JudgeConfig, error) {
+	return LoadInstanceConf(homePath, "")
+}
+
+// LoadInstanceConf loads the configuration of instance, which overrides
+// OJ_INSTANCE in the file. Unless OJ_JUDGER_NAME is set, the judger name of
+// a named instance ends in the instance, so instances sharing a database
+// do not recover each other's solutions.
+func LoadInstanceConf(homePath, instance string) (*JudgeConfig, error) {
+	return LoadSiteConf(homePath, instance, "")
+}
+
+// LoadSiteConf loads the configuration of one site of instance: the
+// settings at the top of the file, overridden by those of its [site.<site>]
+// section.
+func LoadSiteConf(homePath, instance, site string) (*JudgeConfig, error) {
 	config := &JudgeConfig{
 		OJHome: homePath,
+		Judger: defaultJudgerName(),
 		Database: DatabaseConfig{
+			Driver:   "mysql",
+			Path:     filepath.Join(homePath, "hustoj.db"),
 			Host:     "127.0.0.1",
 			Port:     3306,
 			User:     "root",
 			Password: "password",
 			Name:     "hustoj",
 		},
+		HTTP: HTTPConfig{
+			APIPath:   "/admin/problem_judge.php",
+			LoginPath: "/login.php",
+		},
+		Redis: RedisConfig{
+			Server: "127.0.0.1",
+			Port:   6379,
+		},
+		Events: EventsConfig{
+			Retries: 3,
+		},
+		Progress: ProgressConfig{
+			Key:      "hustoj:progress",
+			Interval: 1000,
+		},
+		CPU: CPUConfig{
+			Reserved: "0",
+			NoSMT:    true,
+			Confine:  []string{"mysqld", "mariadbd"},
+		},
 	}
 
-	confPath := fmt.Sprintf("%s/etc/judge.conf", homePath)
-	file, err := os.Open(confPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return config, nil // Return defaults if file doesn't exist
+	judgerSet := false
+	withInstance := func() *JudgeConfig {
+		config.Site = site
+		if instance != "" {
+			config.Instance = instance
 		}
-		return nil, fmt.Errorf("failed to open config file: %w", err)
-	}
-	defer file.Close()
-
-	// Parse key=value pairs
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if len(line) == 0 || line[0] == '#' {
-			continue
-		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
+		if !judgerSet && config.Instance != "" {
+			config.Judger = instanceJudgerName(config.Judger, config.Instance)
 		}
+		return config
+	}
 
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-
+	err := ScanConf(ConfPath(homePath, instance), site, func(key, value string) {
 		switch key {
+		case "OJ_HOME":
+			config.OJHome = value
+			if !filepath.IsAbs(value) {
+				config.OJHome = filepath.Join(homePath, value)
+			}
+		case "OJ_DB_DRIVER":
+			config.Database.Driver = strings.ToLower(value)
+		case "OJ_DB_PATH":
+			config.Database.Path = value
+			if !filepath.IsAbs(value) {
+				config.Database.Path = filepath.Join(homePath, value)
+			}
 		case "OJ_HOST_NAME":
 			config.Database.Host = value
 		case "OJ_PORT_NUMBER":
@@ -77,12 +222,169 @@ func LoadJudgeConf(homePath string) (*JudgeConfig, error) {
 			config.Database.Password = value
 		case "OJ_DB_NAME":
 			config.Database.Name = value
+		case "OJ_JUDGER_NAME":
+			config.Judger = value
+			judgerSet = true
+		case "OJ_INSTANCE":
+			config.Instance = value
+		case "OJ_HTTP_JUDGE":
+			config.HTTP.Enable, _ = strconv.ParseBool(value)
+		case "OJ_HTTP_BASEURL":
+			config.HTTP.BaseURL = value
+		case "OJ_HTTP_API_PATH":
+			config.HTTP.APIPath = value
+		case "OJ_HTTP_LOGIN_PATH":
+			config.HTTP.LoginPath = value
+		case "OJ_HTTP_USERNAME":
+			config.HTTP.Username = value
+		case "OJ_HTTP_PASSWORD":
+			config.HTTP.Password = value
+		case "OJ_REDISSERVER":
+			config.Redis.Server = value
+		case "OJ_REDISPORT":
+			if port, err := strconv.Atoi(value); err == nil {
+				config.Redis.Port = port
+			}
+		case "OJ_REDISAUTH":
+			config.Redis.Auth = value
+		case "OJ_EVENT_WEBHOOK":
+			config.Events.Webhook = value
+		case "OJ_EVENT_WEBHOOK_SECRET":
+			config.Events.Secret = value
+		case "OJ_EVENT_WEBHOOK_RETRIES":
+			config.Events.Retries, _ = strconv.Atoi(value)
+		case "OJ_EVENT_REDIS_CHANNEL":
+			config.Events.Channel = value
+		case "OJ_PROGRESS_TABLE":
+			config.Progress.Table, _ = strconv.ParseBool(value)
+		case "OJ_PROGRESS_REDIS":
+			config.Progress.Redis, _ = strconv.ParseBool(value)
+		case "OJ_PROGRESS_REDIS_KEY":
+			config.Progress.Key = value
+		case "OJ_PROGRESS_INTERVAL":
+			config.Progress.Interval, _ = strconv.Atoi(value)
+		case "OJ_CPU_PIN":
+			config.CPU.Pin, _ = strconv.ParseBool(value)
+		case "OJ_CPU_RESERVED":
+			config.CPU.Reserved = value
+		case "OJ_CPU_NO_SMT":
+			config.CPU.NoSMT, _ = strconv.ParseBool(value)
+		case "OJ_CPU_CONFINE":
+			config.CPU.Confine = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
 		}
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return withInstance(), nil // Return defaults if file doesn't exist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return withInstance(), nil
+}
+
+// ScanConf calls set for each key=value line of the configuration file at
+// path that applies to site: those before the first section, then those of
+// the [site.<site>] section. Other sections are skipped.
+func ScanConf(path, site string, set func(key, value string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		if name, ok := sectionName(line); ok {
+			section = name
+			continue
+		}
+		if section != "" && section != "site."+site {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading config file: %w", err)
+		return fmt.Errorf("error reading config file: %w", err)
 	}
+	return nil
+}
 
-	return config, nil
+// ConfSites lists the [site.<name>] sections of the configuration file at
+// path, in the order they appear.
+func ConfSites(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sites []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name, ok := sectionName(strings.TrimSpace(scanner.Text()))
+		site, isSite := strings.CutPrefix(name, "site.")
+		if !ok || !isSite {
+			continue
+		}
+		if site == "" || ValidateInstance(site) != nil {
+			return nil, fmt.Errorf("invalid site section [%s]", name)
+		}
+		if slices.Contains(sites, site) {
+			return nil, fmt.Errorf("duplicate site section [%s]", name)
+		}
+		sites = append(sites, site)
+	}
+	return sites, scanner.Err()
+}
+
+// sectionName returns the name of a "[name]" section header line.
+func sectionName(line string) (string, bool) {
+	if len(line) < 2 || line[0] != '[' || line[len(line)-1] != ']' {
+		return "", false
+	}
+	return strings.TrimSpace(line[1 : len(line)-1]), true
+}
+
+// hostname is replaced in tests.
+var hostname = os.Hostname
+
+// defaultJudgerName derives the judger identity from the host name, made
+// to fit the judger column of the solution table by fitJudgerName.
+func defaultJudgerName() string {
+	name, err := hostname()
+	if err != nil || name == "" {
+		return "go_judger"
+	}
+	return fitJudgerName(name, name)
+}
+
+// instanceJudgerName appends the instance to the host judger name, made to
+// fit by fitJudgerName so that every pair of host and instance keeps a name
+// of its own.
+func instanceJudgerName(host, instance string) string {
+	return fitJudgerName(host+InstanceSuffix(instance), host+"\x00"+instance)
+}
+
+// fitJudgerName returns name if it fits the 16 bytes of the judger column,
+// and otherwise its first 7 bytes followed by a hash of key. A plain prefix
+// would give hosts like judge-node-1 and judge-node-2 the same name, and
+// with it each other's solutions to recover and leases to renew.
+func fitJudgerName(name, key string) string {
+	if len(name) <= 16 {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return fmt.Sprintf("%s-%08x", name[:7], h.Sum32())
 }
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConf(t *testing.T, home, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(home, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "etc", name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadInstanceConf(t *testing.T) {
+	home := t.TempDir()
+	writeConf(t, home, "judge.conf", "OJ_DB_NAME=shared\n")
+	writeConf(t, home, "judge-b.conf", "OJ_DB_NAME=b\nOJ_JUDGER_NAME=judger_b\n")
+
+	cfg, err := LoadJudgeConf(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Instance != "" || cfg.Judger != defaultJudgerName() {
+		t.Errorf("default instance: instance=%q judger=%q", cfg.Instance, cfg.Judger)
+	}
+
+	a, err := LoadInstanceConf(home, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Instance != "a" || a.Database.Name != "shared" {
+		t.Errorf("instance a without its own file: instance=%q db=%q", a.Instance, a.Database.Name)
+	}
+	if want := instanceJudgerName(defaultJudgerName(), "a"); a.Judger != want {
+		t.Errorf("instance a judger = %q; want %q", a.Judger, want)
+	}
+
+	b, err := LoadInstanceConf(home, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Database.Name != "b" || b.Judger != "judger_b" {
+		t.Errorf("instance b: db=%q judger=%q; want b, judger_b", b.Database.Name, b.Judger)
+	}
+}
+
+func TestLoadSiteConf(t *testing.T) {
+	home := t.TempDir()
+	writeConf(t, home, "judge.conf", `OJ_DB_NAME=shared
+OJ_REDISSERVER=10.0.0.1
+
+[site.school]
+OJ_DB_NAME=school
+OJ_HOME=/srv/school
+
+[site.contest]
+OJ_DB_NAME=contest
+OJ_REDISSERVER=10.0.0.2
+`)
+
+	sites, err := ConfSites(filepath.Join(home, "etc", "judge.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites) != 2 || sites[0] != "school" || sites[1] != "contest" {
+		t.Errorf("sites = %v; want [school contest]", sites)
+	}
+
+	top, err := LoadJudgeConf(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top.Database.Name != "shared" || top.OJHome != home {
+		t.Errorf("top: db=%q home=%q", top.Database.Name, top.OJHome)
+	}
+	school, err := LoadSiteConf(home, "", "school")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if school.Database.Name != "school" || school.OJHome != "/srv/school" || school.Redis.Server != "10.0.0.1" {
+		t.Errorf("school: db=%q home=%q redis=%q", school.Database.Name, school.OJHome, school.Redis.Server)
+	}
+	contest, err := LoadSiteConf(home, "a", "contest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contest.Database.Name != "contest" || contest.Redis.Server != "10.0.0.2" || contest.OJHome != home {
+		t.Errorf("contest: db=%q redis=%q home=%q", contest.Database.Name, contest.Redis.Server, contest.OJHome)
+	}
+	if ns := contest.Namespace(); ns != "a.contest" {
+		t.Errorf("Namespace = %q; want a.contest", ns)
+	}
+}
+
+func TestConfSitesRejectsBadSections(t *testing.T) {
+	for _, content := range []string{"[site.a]\n[site.a]\n", "[site.a/b]\n", "[site.]\n"} {
+		home := t.TempDir()
+		writeConf(t, home, "judge.conf", content)
+		if sites, err := ConfSites(filepath.Join(home, "etc", "judge.conf")); err == nil {
+			t.Errorf("ConfSites(%q) = %v; want an error", content, sites)
+		}
+	}
+}
+
+func TestInstanceJudgerName(t *testing.T) {
+	for _, tc := range []struct{ host, instance, want string }{
+		{"judge1", "a", "judge1-a"},
+		{"judge-node-1", "a", "judge-node-1-a"},
+	} {
+		if got := instanceJudgerName(tc.host, tc.instance); got != tc.want {
+			t.Errorf("instanceJudgerName(%q, %q) = %q; want %q", tc.host, tc.instance, got, tc.want)
+		}
+	}
+
+	seen := make(map[string]string)
+	for _, host := range []string{"judge1", "judge-node-1", "judge-node-2", "averylonghostname"} {
+		for _, instance := range []string{"shard", "instance-abcdef", "contest-2024-spring", "contest-2024-summer"} {
+			name := instanceJudgerName(host, instance)
+			if len(name) > 16 || !strings.HasPrefix(name, host[:min(len(host), 7)]) {
+				t.Errorf("instanceJudgerName(%q, %q) = %q; want at most 16 bytes starting with the host", host, instance, name)
+			}
+			pair := host + "/" + instance
+			if other, ok := seen[name]; ok {
+				t.Errorf("%s and %s share the judger name %q", other, pair, name)
+			}
+			seen[name] = pair
+		}
+	}
+}
+
+func TestDefaultJudgerName(t *testing.T) {
+	realHostname := hostname
+	t.Cleanup(func() { hostname = realHostname })
+
+	names := make(map[string]bool)
+	for _, host := range []string{"judge1", "hustoj-judge-node-1", "hustoj-judge-node-2"} {
+		hostname = func() (string, error) { return host, nil }
+		name := defaultJudgerName()
+		if len(name) > 16 || names[name] {
+			t.Errorf("host %q got judger name %q; want a distinct name of at most 16 bytes", host, name)
+		}
+		names[name] = true
+	}
+	if !names["judge1"] {
+		t.Error("a short host name must be kept as it is")
+	}
+}
+
+func TestValidateInstance(t *testing.T) {
+	for _, name := range []string{"", "a", "oj-2", "site_b"} {
+		if err := ValidateInstance(name); err != nil {
+			t.Errorf("ValidateInstance(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"..", "a/b", "a b", "x.y", "run-1"} {
+		if ValidateInstance(name) == nil {
+			t.Errorf("ValidateInstance(%q) accepted a bad name", name)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+// Package cpuset maps judge runner slots to dedicated CPU cores.
+package cpuset
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+const sysCPUDir = "/sys/devices/system/cpu"
+
+// Topology describes the online CPUs and which of them share a core.
+type Topology struct {
+	Online   []int
+	siblings map[int][]int // CPU -> hardware threads of its core, itself included
+}
+
+// ReadTopology reads the CPU topology from sysfs.
+func ReadTopology() (*Topology, error) {
+	return readTopology(sysCPUDir)
+}
+
+func readTopology(dir string) (*Topology, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "online"))
+	if err != nil {
+		return nil, err
+	}
+	online, err := ParseList(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("bad online cpu list: %w", err)
+	}
+
+	t := &Topology{Online: online, siblings: make(map[int][]int)}
+	for _, cpu := range online {
+		path := filepath.Join(dir, fmt.Sprintf("cpu%d", cpu), "topology", "thread_siblings_list")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.siblings[cpu] = []int{cpu} // No topology info, assume no SMT
+			continue
+		}
+		if t.siblings[cpu], err = ParseList(string(data)); err != nil {
+			return nil, fmt.Errorf("bad sibling list of cpu%d: %w", cpu, err)
+		}
+	}
+	return t, nil
+}
+
+// Siblings returns the hardware threads sharing a core with cpu.
+func (t *Topology) Siblings(cpu int) []int {
+	if s, ok := t.siblings[cpu]; ok {
+		return s
+	}
+	return []int{cpu}
+}
+
+// RunnerCPUs lists the CPUs runner slots are pinned to, in slot order.
+// Reserved CPUs are never used. With avoidSMT only one hardware thread per
+// core is used and cores with a reserved thread are skipped entirely, so a
+// runner never shares a physical core with another runner or the system.
+func (t *Topology) RunnerCPUs(reserved []int, avoidSMT bool) []int {
+	var cpus []int
+	taken := make(map[int]bool)
+	for _, cpu := range reserved {
+		taken[cpu] = true
+	}
+	if avoidSMT {
+		for _, cpu := range reserved {
+			for _, s := range t.Siblings(cpu) {
+				taken[s] = true
+			}
+		}
+	}
+
+	for _, cpu := range t.Online {
+		if taken[cpu] {
+			continue
+		}
+		cpus = append(cpus, cpu)
+		taken[cpu] = true
+		if avoidSMT {
+			for _, s := range t.Siblings(cpu) {
+				taken[s] = true
+			}
+		}
+	}
+	return cpus
+}
+
+// Housekeeping returns the online CPUs not used by the given runner CPUs,
+// for the daemon and the database. With avoidSMT the siblings of runner
+// CPUs are excluded as well.
+func (t *Topology) Housekeeping(runners []int, avoidSMT bool) []int {
+	busy := make(map[int]bool)
+	for _, cpu := range runners {
+		busy[cpu] = true
+		if avoidSMT {
+			for _, s := range t.Siblings(cpu) {
+				busy[s] = true
+			}
+		}
+	}
+
+	var cpus []int
+	for _, cpu := range t.Online {
+		if !busy[cpu] {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
+
+// Plan assigns CPUs to runner slots for a CPUConfig.
+type Plan struct {
+	Runners  []int // CPU of runner slot i is Runners[i % len(Runners)]
+	topology *Topology
+	noSMT    bool
+}
+
+// NewPlan reads the topology and assigns CPUs according to cfg.
+func NewPlan(cfg *config.CPUConfig) (*Plan, error) {
+	reserved, err := ParseList(cfg.Reserved)
+	if err != nil {
+		return nil, fmt.Errorf("bad OJ_CPU_RESERVED %q: %w", cfg.Reserved, err)
+	}
+	topology, err := ReadTopology()
+	if err != nil {
+		return nil, err
+	}
+	runners := topology.RunnerCPUs(reserved, cfg.NoSMT)
+	if len(runners) == 0 {
+		return nil, fmt.Errorf("no CPU left for runners after reserving %q", cfg.Reserved)
+	}
+	return &Plan{Runners: runners, topology: topology, noSMT: cfg.NoSMT}, nil
+}
+
+// Runner returns the CPU of a runner slot.
+func (p *Plan) Runner(slot int) int {
+	return p.Runners[slot%len(p.Runners)]
+}
+
+// Housekeeping returns the CPUs left for everything else when slots
+// runners are in use.
+func (p *Plan) Housekeeping(slots int) []int {
+	used := make([]int, 0, slots)
+	for i := 0; i < slots && i < len(p.Runners); i++ {
+		used = append(used, p.Runners[i])
+	}
+	return p.topology.Housekeeping(used, p.noSMT)
+}
+
+// ParseList parses the kernel's cpu list format, e.g. "0-3,8,10-11".
+func ParseList(s string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(s), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil {
+				return nil, err
+			}
+		}
+		if last < first {
+			return nil, fmt.Errorf("bad cpu range %q", part)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	slices.Sort(cpus)
+	return slices.Compact(cpus), nil
+}
+
+// FormatList formats cpus as a comma separated list for cpuset.cpus.
+func FormatList(cpus []int) string {
+	parts := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		parts[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(parts, ",")
+}
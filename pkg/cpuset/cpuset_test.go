@@ -0,0 +1,73 @@
+package cpuset
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeSysfs builds a cpu directory of 4 cores with 2 threads each, where
+// cpuN and cpuN+4 are siblings.
+func fakeSysfs(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "online"), []byte("0-7\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for cpu := 0; cpu < 8; cpu++ {
+		topo := filepath.Join(dir, fmt.Sprintf("cpu%d", cpu), "topology")
+		if err := os.MkdirAll(topo, 0755); err != nil {
+			t.Fatal(err)
+		}
+		siblings := fmt.Sprintf("%d,%d\n", cpu%4, cpu%4+4)
+		if err := os.WriteFile(filepath.Join(topo, "thread_siblings_list"), []byte(siblings), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestParseList(t *testing.T) {
+	cpus, err := ParseList("0-2, 8,10-11,1\n")
+	if err != nil {
+		t.Fatalf("ParseList: %v", err)
+	}
+	if got := FormatList(cpus); got != "0,1,2,8,10,11" {
+		t.Errorf("ParseList = %v; want 0,1,2,8,10,11", got)
+	}
+	if _, err := ParseList("3-1"); err == nil {
+		t.Error("ParseList accepted a reversed range")
+	}
+}
+
+func TestRunnerCPUsAvoidSMT(t *testing.T) {
+	topo, err := readTopology(fakeSysfs(t))
+	if err != nil {
+		t.Fatalf("readTopology: %v", err)
+	}
+
+	// cpu0 is reserved, so its sibling cpu4 must not run a judgement either.
+	runners := topo.RunnerCPUs([]int{0}, true)
+	if got := FormatList(runners); got != "1,2,3" {
+		t.Errorf("RunnerCPUs = %v; want 1,2,3", got)
+	}
+	if got := FormatList(topo.Housekeeping(runners, true)); got != "0,4" {
+		t.Errorf("Housekeeping = %v; want 0,4", got)
+	}
+}
+
+func TestRunnerCPUsWithSMT(t *testing.T) {
+	topo, err := readTopology(fakeSysfs(t))
+	if err != nil {
+		t.Fatalf("readTopology: %v", err)
+	}
+
+	runners := topo.RunnerCPUs([]int{0, 1}, false)
+	if got := FormatList(runners); got != "2,3,4,5,6,7" {
+		t.Errorf("RunnerCPUs = %v; want 2,3,4,5,6,7", got)
+	}
+	if got := FormatList(topo.Housekeeping(runners[:2], false)); got != "0,1,4,5,6,7" {
+		t.Errorf("Housekeeping = %v; want 0,1,4,5,6,7", got)
+	}
+}
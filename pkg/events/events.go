@@ -0,0 +1,101 @@
+// Package events publishes what happens to a submission while it is being
+// judged, so scoreboards and bots do not have to poll the solution table.
+package events
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+// Type names an event.
+type Type string
+
+const (
+	Accepted    Type = "accepted"     // Taken from the queue by a judger
+	Compiling   Type = "compiling"    // Compilation started
+	Running     Type = "running"      // Test Test of Tests started
+	Verdict     Type = "verdict"      // Final result written
+	SystemError Type = "system_error" // Judging failed, the result is OJ_SE
+
+	// Events about a language rather than a submission, with no solution id.
+	LanguageDisabled Type = "language_disabled" // Not fetched after repeated sandbox system errors
+	LanguageEnabled  Type = "language_enabled"  // Fetched again after a successful probe
+)
+
+// maxMessage bounds the compiler output or error details in an event.
+const maxMessage = 4096
+
+// Event is the JSON document sent to every sink.
+type Event struct {
+	Type        Type      `json:"type"`
+	SolutionID  int       `json:"solution_id"`
+	ProblemID   int       `json:"problem_id,omitempty"`
+	UserID      string    `json:"user_id,omitempty"`
+	ContestID   int       `json:"contest_id,omitempty"`
+	Language    int       `json:"language"`
+	Judger      string    `json:"judger"`
+	Time        time.Time `json:"time"`
+	Test        int       `json:"test,omitempty"`  // 1-based index of the running test
+	Tests       int       `json:"tests,omitempty"` // Number of tests
+	Result      int       `json:"result,omitempty"`
+	ResultName  string    `json:"result_name,omitempty"`
+	TimeMs      int       `json:"time_ms,omitempty"`
+	MemoryKB    int       `json:"memory_kb,omitempty"`
+	PassRate    float64   `json:"pass_rate,omitempty"`
+	QueueWaitMs int64     `json:"queue_wait_ms,omitempty"`
+	Message     string    `json:"message,omitempty"` // Compiler output or error details
+}
+
+// Sink delivers events. Publish must not block judging for long; sinks
+// log delivery failures instead of returning them.
+type Sink interface {
+	Publish(e Event)
+	Close() error
+}
+
+// New returns the sinks configured in judge.conf, or a sink that drops
+// every event if none is.
+func New(cfg *config.JudgeConfig) Sink {
+	var sinks multiSink
+	if cfg.Events.Webhook != "" {
+		sinks = append(sinks, NewWebhook(cfg.Events.Webhook, cfg.Events.Secret, cfg.Events.Retries))
+	}
+	if cfg.Events.Channel != "" {
+		sinks = append(sinks, NewRedis(&cfg.Redis, cfg.Events.Channel))
+	}
+	if len(sinks) == 0 {
+		return Nop{}
+	}
+	return sinks
+}
+
+// Nop drops every event.
+type Nop struct{}
+
+func (Nop) Publish(Event) {}
+func (Nop) Close() error  { return nil }
+
+type multiSink []Sink
+
+func (m multiSink) Publish(e Event) {
+	if len(e.Message) > maxMessage {
+		e.Message = e.Message[:maxMessage]
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, s := range m {
+		s.Publish(e)
+	}
+}
+
+func (m multiSink) Close() error {
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			slog.Warn("Could not close event sink", "err", err)
+		}
+	}
+	return nil
+}
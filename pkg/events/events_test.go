@@ -0,0 +1,174 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/sempr/hustoj-go/pkg/backoff"
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+// hook is a webhook receiver that fails the first failures requests.
+type hook struct {
+	mu       sync.Mutex
+	failures int
+	status   int // Status of the failed requests
+	calls    int
+	received []Event
+	bad      int // Requests with a wrong signature
+}
+
+func (h *hook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls++
+	if h.calls <= h.failures {
+		w.WriteHeader(h.status)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get(SignatureHeader) != "sha256="+Sign([]byte("s3cret"), body) {
+		h.bad++
+	}
+	var e Event
+	json.Unmarshal(body, &e)
+	h.received = append(h.received, e)
+}
+
+func newTestWebhook(url string, retries int) *Webhook {
+	w := NewWebhook(url, "s3cret", retries)
+	w.backoff = backoff.Backoff{Base: time.Millisecond, Max: time.Millisecond}
+	return w
+}
+
+func TestWebhookRetriesAndSigns(t *testing.T) {
+	h := &hook{failures: 2, status: http.StatusBadGateway}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	w := newTestWebhook(srv.URL, 3)
+	w.Publish(Event{Type: Verdict, SolutionID: 7, Result: 4, PassRate: 1})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(h.received) != 1 || h.received[0].SolutionID != 7 || h.received[0].Type != Verdict {
+		t.Fatalf("received = %+v; want the verdict of solution 7", h.received)
+	}
+	if h.calls != 3 {
+		t.Errorf("calls = %d; want 2 failures and a success", h.calls)
+	}
+	if h.bad != 0 {
+		t.Error("signature does not match the body")
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	h := &hook{failures: 1, status: http.StatusUnauthorized}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	w := newTestWebhook(srv.URL, 3)
+	w.Publish(Event{Type: Compiling, SolutionID: 1})
+	w.Close()
+
+	if h.calls != 1 || len(h.received) != 0 {
+		t.Errorf("calls = %d, received = %d; a 401 must not be retried", h.calls, len(h.received))
+	}
+}
+
+func TestWebhookKeepsFinalEvents(t *testing.T) {
+	h := &hook{}
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-release
+		h.ServeHTTP(rw, r)
+	}))
+	defer srv.Close()
+
+	w := newTestWebhook(srv.URL, 0)
+	w.Publish(Event{Type: Accepted, SolutionID: 1}) // Held by the receiver
+	for id := 1; id <= 2*webhookQueue; id++ {
+		w.Publish(Event{Type: Compiling, SolutionID: id})
+		for test := 1; test <= 10; test++ {
+			w.Publish(Event{Type: Running, SolutionID: id, Test: test, Tests: 10})
+		}
+		if id%2 == 0 {
+			w.Publish(Event{Type: Verdict, SolutionID: id, Result: 4})
+		}
+	}
+	close(release)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	verdicts, running := 0, make(map[int]int)
+	for _, e := range h.received {
+		switch e.Type {
+		case Verdict:
+			verdicts++
+		case Running:
+			if e.SolutionID%2 == 0 || e.Test != 10 {
+				t.Errorf("delivered %+v; want only the latest test of unfinished solutions", e)
+			}
+			running[e.SolutionID]++
+		}
+	}
+	if verdicts != webhookQueue {
+		t.Errorf("%d verdicts delivered; want all %d", verdicts, webhookQueue)
+	}
+	if len(running) != webhookQueue {
+		t.Errorf("running events delivered for %d solutions; want %d", len(running), webhookQueue)
+	}
+}
+
+func TestNewPublishesToRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	port, _ := strconv.Atoi(mr.Port())
+	cfg := &config.JudgeConfig{
+		Redis:  config.RedisConfig{Server: mr.Host(), Port: port},
+		Events: config.EventsConfig{Channel: "hustoj:events"},
+	}
+
+	sub := mr.NewSubscriber()
+	defer sub.Close()
+	sub.Subscribe("hustoj:events")
+
+	// miniredis blocks PUBLISH until the subscriber has read the message.
+	messages := make(chan miniredis.PubsubMessage, 1)
+	go func() { messages <- <-sub.Messages() }()
+
+	sink := New(cfg)
+	defer sink.Close()
+	long := make([]byte, 2*maxMessage)
+	sink.Publish(Event{Type: SystemError, SolutionID: 9, Message: string(long)})
+
+	select {
+	case msg := <-messages:
+		var e Event
+		if err := json.Unmarshal([]byte(msg.Message), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != SystemError || e.SolutionID != 9 || e.Time.IsZero() {
+			t.Errorf("event = %+v", e)
+		}
+		if len(e.Message) != maxMessage {
+			t.Errorf("message length = %d; want %d", len(e.Message), maxMessage)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event published")
+	}
+}
+
+func TestNewWithoutSinks(t *testing.T) {
+	if _, ok := New(&config.JudgeConfig{}).(Nop); !ok {
+		t.Error("New without configured sinks should drop events")
+	}
+}
@@ -0,0 +1,47 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+// Redis PUBLISHes every event as JSON on a channel.
+type Redis struct {
+	client  *redis.Client
+	channel string
+}
+
+// NewRedis publishes on channel of the Redis server in cfg. The connection
+// is made on the first event.
+func NewRedis(cfg *config.RedisConfig, channel string) *Redis {
+	return &Redis{
+		client: redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%d", cfg.Server, cfg.Port),
+			Password: cfg.Auth,
+		}),
+		channel: channel,
+	}
+}
+
+func (r *Redis) Publish(e Event) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		slog.Warn("Could not encode event", "err", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := r.client.Publish(ctx, r.channel, payload).Err(); err != nil {
+		slog.Warn("Could not publish event to Redis", "type", e.Type, "solution_id", e.SolutionID, "err", err)
+	}
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
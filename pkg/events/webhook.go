@@ -0,0 +1,207 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/backoff"
+)
+
+const (
+	// webhookQueue bounds the events waiting for delivery; more are dropped,
+	// except final ones.
+	webhookQueue = 256
+	// webhookFlush bounds how long Close waits for queued events.
+	webhookFlush = 10 * time.Second
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the request body, keyed
+// with OJ_EVENT_WEBHOOK_SECRET, as "sha256=<hex>".
+const SignatureHeader = "X-Hustoj-Signature"
+
+// Webhook POSTs every event as JSON to a URL. Delivery happens in the
+// background and is retried with backoff on network errors and 5xx
+// responses.
+//
+// While delivery lags, Running events of a solution are merged: only the
+// latest is sent, and none once its final event is queued. Final events
+// are never dropped, the others once webhookQueue of them are waiting.
+type Webhook struct {
+	url     string
+	secret  []byte
+	retries int
+	client  *http.Client
+	backoff backoff.Backoff
+	done    chan struct{}
+
+	mu        sync.Mutex
+	ready     *sync.Cond    // Signalled when an event is queued or on Close
+	queue     []Event       // Events in order of publication
+	droppable int           // Queued events that are not final
+	running   map[int]Event // Latest Running event by solution, sent after queue
+	closed    bool
+}
+
+// NewWebhook starts delivering to url. An empty secret sends unsigned
+// requests.
+func NewWebhook(url, secret string, retries int) *Webhook {
+	w := &Webhook{
+		url:     url,
+		secret:  []byte(secret),
+		retries: retries,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		backoff: backoff.Backoff{Base: 500 * time.Millisecond, Max: 5 * time.Second},
+		done:    make(chan struct{}),
+		running: make(map[int]Event),
+	}
+	w.ready = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// final reports whether an event ends a judgement or changes a language,
+// which receivers must not miss.
+func final(typ Type) bool {
+	switch typ {
+	case Verdict, SystemError, LanguageDisabled, LanguageEnabled:
+		return true
+	}
+	return false
+}
+
+func (w *Webhook) Publish(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	switch {
+	case e.Type == Running:
+		w.running[e.SolutionID] = e
+	case final(e.Type):
+		delete(w.running, e.SolutionID) // Superseded by the final event
+		w.queue = append(w.queue, e)
+	case w.droppable >= webhookQueue:
+		slog.Warn("Webhook queue full, dropping event", "type", e.Type, "solution_id", e.SolutionID)
+		return
+	default:
+		w.droppable++
+		w.queue = append(w.queue, e)
+	}
+	w.ready.Signal()
+}
+
+// Close delivers the queued events, giving up after webhookFlush.
+func (w *Webhook) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.ready.Signal()
+	w.mu.Unlock()
+	select {
+	case <-w.done:
+		return nil
+	case <-time.After(webhookFlush):
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return fmt.Errorf("webhook: %d events not delivered", len(w.queue)+len(w.running))
+	}
+}
+
+func (w *Webhook) run() {
+	defer close(w.done)
+	for {
+		e, ok := w.next()
+		if !ok {
+			return
+		}
+		if err := w.deliver(e); err != nil {
+			slog.Warn("Could not deliver event to webhook", "type", e.Type, "solution_id", e.SolutionID, "err", err)
+		}
+	}
+}
+
+// next waits for the next event to deliver: the oldest queued one, else
+// the merged Running event of the lowest solution id. It returns false
+// once the webhook is closed and everything was delivered.
+func (w *Webhook) next() (Event, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for len(w.queue) == 0 && len(w.running) == 0 && !w.closed {
+		w.ready.Wait()
+	}
+	if len(w.queue) > 0 {
+		e := w.queue[0]
+		w.queue = w.queue[1:]
+		if !final(e.Type) {
+			w.droppable--
+		}
+		return e, true
+	}
+	if len(w.running) > 0 {
+		id := -1
+		for solutionID := range w.running {
+			if id < 0 || solutionID < id {
+				id = solutionID
+			}
+		}
+		e := w.running[id]
+		delete(w.running, id)
+		return e, true
+	}
+	return Event{}, false
+}
+
+func (w *Webhook) deliver(e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return backoff.Retry(context.Background(), w.retries+1, w.backoff, func() error {
+		return w.post(e.Type, body)
+	})
+}
+
+func (w *Webhook) post(typ Type, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return backoff.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hustoj-Event", string(typ))
+	if len(w.secret) > 0 {
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return backoff.Permanent(fmt.Errorf("webhook answered %s", resp.Status))
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body, for receivers to compare with
+// the SignatureHeader.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,6 +1,8 @@
 package interfaces
 
 import (
+	"time"
+
 	"github.com/sempr/hustoj-go/pkg/models"
 )
 
@@ -44,8 +46,14 @@ type Solution struct {
 	UserID    string
 	Language  int
 	ContestID int
+	InDate    time.Time // Submission time, zero if the backend does not provide it
+	Result    int       // Current verdict, ResultUnknown if the backend does not provide it
 }
 
+// ResultUnknown is the Result of a solution read from a backend that does
+// not report verdicts, such as the HTTP judge API.
+const ResultUnknown = -1
+
 type Problem struct {
 	ID        int
 	TimeLimit float64
@@ -60,9 +68,10 @@ type LangBasic struct {
 }
 
 type LangConfig struct {
-	Name string  `toml:"name"`
-	Fs   FsInfo  `toml:"fs"`
-	Cmd  CmdInfo `toml:"cmd"`
+	Name  string    `toml:"name"`
+	Fs    FsInfo    `toml:"fs"`
+	Cmd   CmdInfo   `toml:"cmd"`
+	Sched SchedInfo `toml:"sched"`
 }
 
 type FsInfo struct {
@@ -77,6 +86,12 @@ type CmdInfo struct {
 	Env     []string `toml:"env"`
 }
 
+type SchedInfo struct {
+	Weight         int `toml:"weight"`
+	MaxRunning     int `toml:"max_running"`
+	MemoryOverhead int `toml:"memory_overhead"`
+}
+
 // SandboxExecutor defines interface for executing code in sandbox
 type SandboxExecutor interface {
 	Compile(rootfs string, cmd string, env []string, solutionID int) (*models.SandboxOutput, error)
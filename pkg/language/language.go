@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/pelletier/go-toml/v2"
 )
@@ -17,9 +18,10 @@ type LangBasic struct {
 
 // LangConfig represents complete language configuration
 type LangConfig struct {
-	Name string  `toml:"name"`
-	Fs   FsInfo  `toml:"fs"`
-	Cmd  CmdInfo `toml:"cmd"`
+	Name  string    `toml:"name"`
+	Fs    FsInfo    `toml:"fs"`
+	Cmd   CmdInfo   `toml:"cmd"`
+	Sched SchedInfo `toml:"sched"`
 }
 
 // FsInfo represents filesystem information for a language
@@ -36,8 +38,16 @@ type CmdInfo struct {
 	Env     []string `toml:"env"`
 }
 
+// SchedInfo tells the daemon how heavy a language is to judge
+type SchedInfo struct {
+	Weight         int `toml:"weight"`          // Slots of OJ_RUNNING one judgement takes, 0 means 1
+	MaxRunning     int `toml:"max_running"`     // Concurrent judgements of this language, 0 for no limit
+	MemoryOverhead int `toml:"memory_overhead"` // MB needed beyond the problem's memory limit, 0 means 64
+}
+
 // Manager manages language configurations
 type Manager struct {
+	mu      sync.RWMutex
 	langMap map[int]LangBasic
 	homeDir string
 }
@@ -71,15 +81,27 @@ func (m *Manager) loadLanguageMap() error {
 		return fmt.Errorf("failed to parse all.toml: %w", err)
 	}
 
+	langMap := make(map[int]LangBasic, len(config.Lang))
 	for _, lang := range config.Lang {
-		m.langMap[lang.ID] = lang
+		langMap[lang.ID] = lang
 	}
 
+	m.mu.Lock()
+	m.langMap = langMap
+	m.mu.Unlock()
 	return nil
 }
 
+// Reload reads all.toml again, so languages added since start become
+// known. If the file cannot be read or parsed, the old mapping is kept.
+func (m *Manager) Reload() error {
+	return m.loadLanguageMap()
+}
+
 // GetLanguageBasic returns basic language information
 func (m *Manager) GetLanguageBasic(langID int) (LangBasic, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	lang, ok := m.langMap[langID]
 	if !ok {
 		return LangBasic{}, fmt.Errorf("unknown language ID: %d", langID)
@@ -106,6 +128,8 @@ func (m *Manager) GetLanguageConfig(langID int) (*LangConfig, error) {
 
 // GetAllLanguages returns all available languages
 func (m *Manager) GetAllLanguages() map[int]LangBasic {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 	result := make(map[int]LangBasic)
 	for k, v := range m.langMap {
 		result[k] = v
@@ -10,10 +10,13 @@ type SandboxArgs struct {
 	TimeLimit   int
 	MemoryLimit int
 	SolutionId  int
+	CPUs        string // cpuset.cpus of the run, empty to run unpinned
+	Instance    string // Namespace of the daemon instance and site whose cgroup subtree the run goes in
 }
 
 type DaemonArgs struct {
-	OJHome string
-	Debug  bool
-	Once   bool
+	OJHome   string
+	Instance string // Overrides OJ_INSTANCE
+	Debug    bool
+	Once     bool
 }
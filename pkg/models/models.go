@@ -35,3 +35,18 @@ type TotalResults struct {
 	Results     []OneResult `json:"results"`
 	FinalResult int         `json:"final_result"`
 }
+
+// JudgeReport 是评测客户端结束时交给守护进程的摘要，用于监控统计。
+// 外部进程模式下通过 HUSTOJ_REPORT_FD 指定的文件描述符以 JSON 传递。
+type JudgeReport struct {
+	// Language 是提交所用的语言编号。
+	Language int `json:"language"`
+	// Result 是最终写入数据库的判题结果，0 表示未得出结果。
+	Result int `json:"result"`
+	// QueueWaitMs 是从提交到开始评测的等待时间（毫秒），未知时为 0。
+	QueueWaitMs int64 `json:"queue_wait_ms"`
+	// CompileMs 是编译耗时（毫秒）。
+	CompileMs int64 `json:"compile_ms"`
+	// SystemErrors 是沙箱报告系统错误的次数。
+	SystemErrors int `json:"system_errors"`
+}
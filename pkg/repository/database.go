@@ -7,33 +7,41 @@ import (
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
 )
 
 // Solution represents a solution record
-type Solution struct {
-	ID        int
-	ProblemID int
-	UserID    string
-	Language  int
-	ContestID int
-}
+type Solution = interfaces.Solution
 
 // Problem represents a problem record
-type Problem struct {
-	ID        int
-	TimeLimit float64
-	MemLimit  int
-	SPJ       int
+type Problem = interfaces.Problem
+
+// Open returns the database backend selected by the judge configuration
+func Open(cfg *config.JudgeConfig) (interfaces.Database, error) {
+	if cfg.HTTP.Enable {
+		return NewHTTPDatabase(&cfg.HTTP)
+	}
+	newDatabase := NewDatabase
+	if cfg.Database.Driver == "sqlite" {
+		newDatabase = NewSQLiteDatabase
+	}
+	db, err := newDatabase(&cfg.Database)
+	if err != nil {
+		return nil, err
+	}
+	db.judger = cfg.Judger
+	return db, nil
 }
 
 // Database handles all database operations
 type Database struct {
-	db *sql.DB
+	db     *sql.DB
+	judger string // Written to solution.judger with every update
 }
 
 // NewDatabase creates a new database connection
 func NewDatabase(cfg *config.DatabaseConfig) (*Database, error) {
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8",
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=true&loc=Local&timeout=10s&readTimeout=30s&writeTimeout=30s",
 		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
 
 	db, err := sql.Open("mysql", dsn)
@@ -53,7 +61,7 @@ func NewDatabase(cfg *config.DatabaseConfig) (*Database, error) {
 		return nil, fmt.Errorf("failed to set UTF8: %w", err)
 	}
 
-	return &Database{db: db}, nil
+	return &Database{db: db, judger: "go_judger"}, nil
 }
 
 // Close closes the database connection
@@ -63,11 +71,11 @@ func (d *Database) Close() error {
 
 // GetSolution retrieves solution information
 func (d *Database) GetSolution(solutionID int) (*Solution, error) {
-	query := "SELECT problem_id, user_id, language, contest_id FROM solution WHERE solution_id = ?"
+	query := "SELECT problem_id, user_id, language, contest_id, in_date, result FROM solution WHERE solution_id = ?"
 	var nullCID sql.NullInt64
 
 	solution := &Solution{ID: solutionID}
-	err := d.db.QueryRow(query, solutionID).Scan(&solution.ProblemID, &solution.UserID, &solution.Language, &nullCID)
+	err := d.db.QueryRow(query, solutionID).Scan(&solution.ProblemID, &solution.UserID, &solution.Language, &nullCID, &solution.InDate, &solution.Result)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get solution info: %w", err)
 	}
@@ -108,7 +116,7 @@ func (d *Database) GetSolutionSource(solutionID int) (string, error) {
 // UpdateSolution updates solution status and results
 func (d *Database) UpdateSolution(solutionID, result, timeUsed, memoryUsed int, passRate float64) error {
 	query := "UPDATE solution SET result=?, time=?, memory=?, pass_rate=?, judger=?, judgetime=now() WHERE solution_id=?"
-	_, err := d.db.Exec(query, result, timeUsed, memoryUsed, passRate, "go_judger", solutionID)
+	_, err := d.db.Exec(query, result, timeUsed, memoryUsed, passRate, d.judger, solutionID)
 	if err != nil {
 		return fmt.Errorf("failed to update solution: %w", err)
 	}
@@ -0,0 +1,296 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+)
+
+// HTTPSession talks to the HUSTOJ admin judge API (problem_judge.php)
+// with a logged-in session cookie
+type HTTPSession struct {
+	cfg     *config.HTTPConfig
+	client  *http.Client
+	loginMu sync.Mutex // Serializes logins after the session expired
+}
+
+// notLoggedIn is part of the page the judge API answers with, still with
+// status 200, once the session has expired.
+const notLoggedIn = "Please Login First"
+
+// NewHTTPSession creates a session for the configured web frontend.
+// It does not log in; call EnsureLogin before the first API call.
+func NewHTTPSession(cfg *config.HTTPConfig) (*HTTPSession, error) {
+	if cfg.BaseURL == "" {
+		return nil, fmt.Errorf("OJ_HTTP_BASEURL is not set")
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cookie jar: %w", err)
+	}
+
+	return &HTTPSession{
+		cfg: cfg,
+		client: &http.Client{
+			Jar:     jar,
+			Timeout: 30 * time.Second,
+		},
+	}, nil
+}
+
+// Login posts the judge account credentials to the login page
+func (s *HTTPSession) Login() error {
+	form := url.Values{
+		"user_id":  {s.cfg.Username},
+		"password": {s.cfg.Password},
+	}
+	if _, err := s.post(s.cfg.LoginPath, form); err != nil {
+		return fmt.Errorf("failed to login: %w", err)
+	}
+
+	ok, err := s.CheckLogin()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("login rejected for user %s", s.cfg.Username)
+	}
+	return nil
+}
+
+// CheckLogin reports whether the session cookie is still accepted by the API
+func (s *HTTPSession) CheckLogin() (bool, error) {
+	body, err := s.post(s.cfg.APIPath, url.Values{"checklogin": {"1"}})
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(body) == "1", nil
+}
+
+// EnsureLogin logs in again if the session has expired
+func (s *HTTPSession) EnsureLogin() error {
+	ok, err := s.CheckLogin()
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	return s.Login()
+}
+
+// Call posts form to the judge API and returns the raw response body. If
+// the session has expired it logs in again and retries the call once.
+func (s *HTTPSession) Call(form url.Values) (string, error) {
+	body, err := s.post(s.cfg.APIPath, form)
+	if err != nil || !strings.Contains(body, notLoggedIn) {
+		return body, err
+	}
+	if err := s.relogin(); err != nil {
+		return "", fmt.Errorf("session expired: %w", err)
+	}
+	body, err = s.post(s.cfg.APIPath, form)
+	if err == nil && strings.Contains(body, notLoggedIn) {
+		return "", fmt.Errorf("session expired again right after login")
+	}
+	return body, err
+}
+
+// relogin logs in unless another call did so while this one waited.
+func (s *HTTPSession) relogin() error {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+	return s.EnsureLogin()
+}
+
+// Close releases idle connections held by the session
+func (s *HTTPSession) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+func (s *HTTPSession) post(path string, form url.Values) (string, error) {
+	resp, err := s.client.PostForm(strings.TrimRight(s.cfg.BaseURL, "/")+path, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response from %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status from %s: %s", path, resp.Status)
+	}
+	return string(body), nil
+}
+
+// HTTPDatabase implements the judge database operations on top of the
+// web admin API, for judge hosts that cannot reach MySQL directly
+type HTTPDatabase struct {
+	session *HTTPSession
+}
+
+// NewHTTPDatabase creates a logged-in HTTP database backend
+func NewHTTPDatabase(cfg *config.HTTPConfig) (*HTTPDatabase, error) {
+	session, err := NewHTTPSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := session.EnsureLogin(); err != nil {
+		return nil, fmt.Errorf("failed to connect to judge API: %w", err)
+	}
+	return &HTTPDatabase{session: session}, nil
+}
+
+// Close closes the HTTP session
+func (d *HTTPDatabase) Close() error {
+	return d.session.Close()
+}
+
+// GetSolution retrieves solution information
+func (d *HTTPDatabase) GetSolution(solutionID int) (*Solution, error) {
+	body, err := d.session.Call(url.Values{
+		"getsolutioninfo": {"1"},
+		"sid":             {strconv.Itoa(solutionID)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get solution info: %w", err)
+	}
+
+	fields := strings.Fields(body)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("failed to get solution info: unexpected response %q", body)
+	}
+
+	solution := &Solution{ID: solutionID, UserID: fields[1], Result: interfaces.ResultUnknown}
+	if solution.ProblemID, err = strconv.Atoi(fields[0]); err != nil {
+		return nil, fmt.Errorf("failed to parse problem id: %w", err)
+	}
+	if solution.Language, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, fmt.Errorf("failed to parse language: %w", err)
+	}
+	// Newer frontends also return the contest id
+	if len(fields) > 3 {
+		solution.ContestID, _ = strconv.Atoi(fields[3])
+	}
+
+	return solution, nil
+}
+
+// GetProblem retrieves problem information
+func (d *HTTPDatabase) GetProblem(problemID int) (*Problem, error) {
+	body, err := d.session.Call(url.Values{
+		"getprobleminfo": {"1"},
+		"pid":            {strconv.Itoa(problemID)},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get problem info: %w", err)
+	}
+
+	fields := strings.Fields(body)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("failed to get problem info: unexpected response %q", body)
+	}
+
+	problem := &Problem{ID: problemID}
+	if problem.TimeLimit, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return nil, fmt.Errorf("failed to parse time limit: %w", err)
+	}
+	if problem.MemLimit, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, fmt.Errorf("failed to parse memory limit: %w", err)
+	}
+	if problem.SPJ, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, fmt.Errorf("failed to parse spj: %w", err)
+	}
+
+	return problem, nil
+}
+
+// GetSolutionSource retrieves the source code for a solution
+func (d *HTTPDatabase) GetSolutionSource(solutionID int) (string, error) {
+	source, err := d.session.Call(url.Values{
+		"getsolution": {"1"},
+		"sid":         {strconv.Itoa(solutionID)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get solution source: %w", err)
+	}
+	return source, nil
+}
+
+// UpdateSolution updates solution status and results
+func (d *HTTPDatabase) UpdateSolution(solutionID, result, timeUsed, memoryUsed int, passRate float64) error {
+	_, err := d.session.Call(url.Values{
+		"update_solution": {"1"},
+		"sid":             {strconv.Itoa(solutionID)},
+		"result":          {strconv.Itoa(result)},
+		"time":            {strconv.Itoa(timeUsed)},
+		"memory":          {strconv.Itoa(memoryUsed)},
+		"sim":             {"0"},
+		"simid":           {"0"},
+		"pass_rate":       {strconv.FormatFloat(passRate, 'f', 2, 64)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update solution: %w", err)
+	}
+	return nil
+}
+
+// UpdateUserStats updates user solve and submit statistics
+func (d *HTTPDatabase) UpdateUserStats(userID string) error {
+	if _, err := d.session.Call(url.Values{
+		"updateuser": {"1"},
+		"user_id":    {userID},
+	}); err != nil {
+		return fmt.Errorf("failed to update user stats: %w", err)
+	}
+	return nil
+}
+
+// UpdateProblemStats updates problem statistics
+func (d *HTTPDatabase) UpdateProblemStats(problemID, contestID int) error {
+	if _, err := d.session.Call(url.Values{
+		"updateproblem": {"1"},
+		"pid":           {strconv.Itoa(problemID)},
+		"cid":           {strconv.Itoa(contestID)},
+	}); err != nil {
+		return fmt.Errorf("failed to update problem stats: %w", err)
+	}
+	return nil
+}
+
+// AddCompileError adds compilation error information
+func (d *HTTPDatabase) AddCompileError(solutionID int, message string) error {
+	if _, err := d.session.Call(url.Values{
+		"addceinfo": {"1"},
+		"sid":       {strconv.Itoa(solutionID)},
+		"ceinfo":    {message},
+	}); err != nil {
+		return fmt.Errorf("failed to add compile info: %w", err)
+	}
+	return nil
+}
+
+// AddRuntimeInfo adds runtime error information
+func (d *HTTPDatabase) AddRuntimeInfo(solutionID int, details string) error {
+	if _, err := d.session.Call(url.Values{
+		"addreinfo": {"1"},
+		"sid":       {strconv.Itoa(solutionID)},
+		"reinfo":    {details},
+	}); err != nil {
+		return fmt.Errorf("failed to add runtime info: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+// fakeJudgeAPI is a minimal stand-in for login.php and admin/problem_judge.php
+type fakeJudgeAPI struct {
+	mu       sync.Mutex
+	sessions map[string]bool
+	logins   int
+	calls    map[string][]string // action -> posted values of interest
+}
+
+func newFakeJudgeAPI() *fakeJudgeAPI {
+	return &fakeJudgeAPI{sessions: map[string]bool{}, calls: map[string][]string{}}
+}
+
+func (f *fakeJudgeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/login.php":
+		if r.PostForm.Get("user_id") != "judge" || r.PostForm.Get("password") != "secret" {
+			return
+		}
+		f.logins++
+		sid := fmt.Sprintf("s%d", f.logins)
+		f.sessions[sid] = true
+		http.SetCookie(w, &http.Cookie{Name: "PHPSESSID", Value: sid, Path: "/"})
+		return
+	case "/admin/problem_judge.php":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	c, err := r.Cookie("PHPSESSID")
+	loggedIn := err == nil && f.sessions[c.Value]
+
+	switch {
+	case r.PostForm.Has("checklogin"):
+		if loggedIn {
+			fmt.Fprint(w, "1")
+		}
+	case !loggedIn:
+		fmt.Fprint(w, "<a href='../loginpage.php'>Please Login First!</a>")
+	case r.PostForm.Has("getsolutioninfo"):
+		fmt.Fprint(w, "1000\nalice\n1\n")
+	case r.PostForm.Has("getprobleminfo"):
+		fmt.Fprint(w, "1.5\n128\n0\n")
+	case r.PostForm.Has("getsolution"):
+		fmt.Fprint(w, "int main() { return 0; }\n")
+	case r.PostForm.Has("update_solution"):
+		f.calls["update_solution"] = append(f.calls["update_solution"],
+			r.PostForm.Get("result")+"/"+r.PostForm.Get("pass_rate"))
+	case r.PostForm.Has("addreinfo"):
+		f.calls["addreinfo"] = append(f.calls["addreinfo"], r.PostForm.Get("reinfo"))
+	}
+}
+
+func newTestHTTPConfig(url string) *config.HTTPConfig {
+	return &config.HTTPConfig{
+		Enable:    true,
+		BaseURL:   url,
+		APIPath:   "/admin/problem_judge.php",
+		LoginPath: "/login.php",
+		Username:  "judge",
+		Password:  "secret",
+	}
+}
+
+func TestHTTPDatabase(t *testing.T) {
+	api := newFakeJudgeAPI()
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	db, err := NewHTTPDatabase(newTestHTTPConfig(srv.URL))
+	if err != nil {
+		t.Fatalf("NewHTTPDatabase: %v", err)
+	}
+	defer db.Close()
+
+	solution, err := db.GetSolution(42)
+	if err != nil {
+		t.Fatalf("GetSolution: %v", err)
+	}
+	if solution.ProblemID != 1000 || solution.UserID != "alice" || solution.Language != 1 {
+		t.Errorf("GetSolution = %+v", solution)
+	}
+
+	problem, err := db.GetProblem(1000)
+	if err != nil {
+		t.Fatalf("GetProblem: %v", err)
+	}
+	if problem.TimeLimit != 1.5 || problem.MemLimit != 128 || problem.SPJ != 0 {
+		t.Errorf("GetProblem = %+v", problem)
+	}
+
+	source, err := db.GetSolutionSource(42)
+	if err != nil || source != "int main() { return 0; }\n" {
+		t.Errorf("GetSolutionSource = %q, %v", source, err)
+	}
+
+	if err := db.UpdateSolution(42, 4, 10, 1024, 1.0); err != nil {
+		t.Fatalf("UpdateSolution: %v", err)
+	}
+	if err := db.AddRuntimeInfo(42, "details"); err != nil {
+		t.Fatalf("AddRuntimeInfo: %v", err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if got := api.calls["update_solution"]; len(got) != 1 || got[0] != "4/1.00" {
+		t.Errorf("update_solution calls = %v", got)
+	}
+	if got := api.calls["addreinfo"]; len(got) != 1 || got[0] != "details" {
+		t.Errorf("addreinfo calls = %v", got)
+	}
+}
+
+func TestHTTPSessionRelogin(t *testing.T) {
+	api := newFakeJudgeAPI()
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	session, err := NewHTTPSession(newTestHTTPConfig(srv.URL))
+	if err != nil {
+		t.Fatalf("NewHTTPSession: %v", err)
+	}
+	if err := session.EnsureLogin(); err != nil {
+		t.Fatalf("EnsureLogin: %v", err)
+	}
+
+	// Expire every session on the server side.
+	api.mu.Lock()
+	api.sessions = map[string]bool{}
+	api.mu.Unlock()
+
+	if err := session.EnsureLogin(); err != nil {
+		t.Fatalf("EnsureLogin after expiry: %v", err)
+	}
+	if ok, err := session.CheckLogin(); err != nil || !ok {
+		t.Errorf("CheckLogin = %v, %v; want true", ok, err)
+	}
+	if api.logins != 2 {
+		t.Errorf("logins = %d; want 2", api.logins)
+	}
+}
+
+func TestHTTPDatabaseReloginAfterExpiry(t *testing.T) {
+	api := newFakeJudgeAPI()
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	db, err := NewHTTPDatabase(newTestHTTPConfig(srv.URL))
+	if err != nil {
+		t.Fatalf("NewHTTPDatabase: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.GetSolution(42); err != nil {
+		t.Fatalf("GetSolution: %v", err)
+	}
+
+	// The PHP session expires between two calls of a long-lived client.
+	api.mu.Lock()
+	api.sessions = map[string]bool{}
+	api.mu.Unlock()
+
+	source, err := db.GetSolutionSource(42)
+	if err != nil || source != "int main() { return 0; }\n" {
+		t.Errorf("GetSolutionSource after expiry = %q, %v", source, err)
+	}
+	if err := db.UpdateSolution(42, 4, 10, 1024, 1.0); err != nil {
+		t.Fatalf("UpdateSolution: %v", err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if got := api.calls["update_solution"]; len(got) != 1 {
+		t.Errorf("update_solution calls = %v; want the verdict written", got)
+	}
+	if api.logins != 2 {
+		t.Errorf("logins = %d; want 2", api.logins)
+	}
+}
+
+func TestHTTPSessionBadCredentials(t *testing.T) {
+	srv := httptest.NewServer(newFakeJudgeAPI())
+	defer srv.Close()
+
+	cfg := newTestHTTPConfig(srv.URL)
+	cfg.Password = "wrong"
+	if _, err := NewHTTPDatabase(cfg); err == nil {
+		t.Error("expected login failure with bad credentials")
+	}
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+// Progress is how far the judgement of a solution got.
+type Progress struct {
+	SolutionID int
+	Done       int   // Tests finished
+	Total      int   // Tests of the problem
+	Verdicts   []int // Result of every finished test, in order
+}
+
+// verdictList formats the verdicts as a comma separated list of results.
+func (p Progress) verdictList() string {
+	parts := make([]string, len(p.Verdicts))
+	for i, v := range p.Verdicts {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ProgressWriter is implemented by stores that can show live progress.
+type ProgressWriter interface {
+	WriteProgress(p Progress) error
+	ClearProgress(solutionID int) error
+}
+
+// WriteProgress stores p in the solution_progress table.
+func (d *Database) WriteProgress(p Progress) error {
+	_, err := d.db.Exec("REPLACE INTO solution_progress (solution_id, done, total, verdicts, updated) VALUES (?, ?, ?, ?, NOW())",
+		p.SolutionID, p.Done, p.Total, p.verdictList())
+	if err != nil {
+		return fmt.Errorf("failed to write progress: %w", err)
+	}
+	return nil
+}
+
+// ClearProgress removes the progress of a judged solution.
+func (d *Database) ClearProgress(solutionID int) error {
+	if _, err := d.db.Exec("DELETE FROM solution_progress WHERE solution_id=?", solutionID); err != nil {
+		return fmt.Errorf("failed to clear progress: %w", err)
+	}
+	return nil
+}
+
+// progressTTL lets Redis forget the progress of a judgement that died.
+const progressTTL = time.Hour
+
+// RedisProgress stores progress in a Redis hash per solution, named
+// "<prefix>:<solution_id>", with the fields done, total and verdicts.
+type RedisProgress struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisProgress writes progress to the Redis server in cfg.
+func NewRedisProgress(cfg *config.RedisConfig, prefix string) *RedisProgress {
+	return &RedisProgress{
+		client: redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%d", cfg.Server, cfg.Port),
+			Password: cfg.Auth,
+		}),
+		prefix: prefix,
+	}
+}
+
+func (r *RedisProgress) key(solutionID int) string {
+	return r.prefix + ":" + strconv.Itoa(solutionID)
+}
+
+func (r *RedisProgress) WriteProgress(p Progress) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	key := r.key(p.SolutionID)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "done", p.Done, "total", p.Total, "verdicts", p.verdictList())
+		pipe.Expire(ctx, key, progressTTL)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisProgress) ClearProgress(solutionID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return r.client.Del(ctx, r.key(solutionID)).Err()
+}
+
+func (r *RedisProgress) Close() error {
+	return r.client.Close()
+}
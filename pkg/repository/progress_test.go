@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+func TestRedisProgress(t *testing.T) {
+	mr := miniredis.RunT(t)
+	port, _ := strconv.Atoi(mr.Port())
+	r := NewRedisProgress(&config.RedisConfig{Server: mr.Host(), Port: port}, "hustoj:progress")
+	defer r.Close()
+
+	if err := r.WriteProgress(Progress{SolutionID: 9, Done: 2, Total: 3, Verdicts: []int{4, 6}}); err != nil {
+		t.Fatal(err)
+	}
+	key := "hustoj:progress:9"
+	for field, want := range map[string]string{"done": "2", "total": "3", "verdicts": "4,6"} {
+		if got := mr.HGet(key, field); got != want {
+			t.Errorf("%s = %q; want %q", field, got, want)
+		}
+	}
+	if mr.TTL(key) != progressTTL {
+		t.Errorf("ttl = %v; want %v", mr.TTL(key), progressTTL)
+	}
+
+	if err := r.ClearProgress(9); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(key) {
+		t.Error("progress still stored after ClearProgress")
+	}
+}
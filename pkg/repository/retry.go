@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/backoff"
+	"github.com/sempr/hustoj-go/pkg/config"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+)
+
+const (
+	// retryAttempts bounds how often a database call is tried. With
+	// retryBackoff the retries span about 15 seconds, enough to ride out a
+	// server restart without holding a judge slot much longer.
+	retryAttempts = 5
+)
+
+var retryBackoff = backoff.Backoff{Base: time.Second, Max: 8 * time.Second}
+
+// retryDatabase retries failed calls of a Database. All of its operations
+// are idempotent: updates overwrite and info rows are replaced.
+type retryDatabase struct {
+	interfaces.Database
+	attempts int
+	backoff  backoff.Backoff
+}
+
+// WithRetry wraps db so that calls failing with transient errors are
+// retried with exponential backoff. Missing rows are not retried.
+func WithRetry(db interfaces.Database) interfaces.Database {
+	return &retryDatabase{Database: db, attempts: retryAttempts, backoff: retryBackoff}
+}
+
+// OpenWithRetry opens the database backend like Open, retrying while the
+// server cannot be reached.
+func OpenWithRetry(cfg *config.JudgeConfig) (interfaces.Database, error) {
+	var db interfaces.Database
+	err := backoff.Retry(context.Background(), retryAttempts, retryBackoff, func() error {
+		var err error
+		if db, err = Open(cfg); err != nil {
+			slog.Warn("Could not open the database", "err", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return WithRetry(db), nil
+}
+
+// Unwrap returns the wrapped database, for optional interfaces such as
+// ProgressWriter.
+func (r *retryDatabase) Unwrap() interfaces.Database {
+	return r.Database
+}
+
+func (r *retryDatabase) retry(op string, fn func() error) error {
+	return backoff.Retry(context.Background(), r.attempts, r.backoff, func() error {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return backoff.Permanent(err)
+		}
+		slog.Warn("Database call failed", "op", op, "err", err)
+		return err
+	})
+}
+
+func (r *retryDatabase) GetSolution(solutionID int) (s *Solution, err error) {
+	err = r.retry("GetSolution", func() error {
+		s, err = r.Database.GetSolution(solutionID)
+		return err
+	})
+	return s, err
+}
+
+func (r *retryDatabase) GetProblem(problemID int) (p *Problem, err error) {
+	err = r.retry("GetProblem", func() error {
+		p, err = r.Database.GetProblem(problemID)
+		return err
+	})
+	return p, err
+}
+
+func (r *retryDatabase) GetSolutionSource(solutionID int) (source string, err error) {
+	err = r.retry("GetSolutionSource", func() error {
+		source, err = r.Database.GetSolutionSource(solutionID)
+		return err
+	})
+	return source, err
+}
+
+func (r *retryDatabase) UpdateSolution(solutionID, result, timeUsed, memoryUsed int, passRate float64) error {
+	return r.retry("UpdateSolution", func() error {
+		return r.Database.UpdateSolution(solutionID, result, timeUsed, memoryUsed, passRate)
+	})
+}
+
+func (r *retryDatabase) UpdateUserStats(userID string) error {
+	return r.retry("UpdateUserStats", func() error { return r.Database.UpdateUserStats(userID) })
+}
+
+func (r *retryDatabase) UpdateProblemStats(problemID, contestID int) error {
+	return r.retry("UpdateProblemStats", func() error { return r.Database.UpdateProblemStats(problemID, contestID) })
+}
+
+func (r *retryDatabase) AddCompileError(solutionID int, message string) error {
+	return r.retry("AddCompileError", func() error { return r.Database.AddCompileError(solutionID, message) })
+}
+
+func (r *retryDatabase) AddRuntimeInfo(solutionID int, details string) error {
+	return r.retry("AddRuntimeInfo", func() error { return r.Database.AddRuntimeInfo(solutionID, details) })
+}
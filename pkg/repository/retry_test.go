@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/backoff"
+	"github.com/sempr/hustoj-go/pkg/interfaces"
+)
+
+// flakyDB fails the first failures calls of every operation.
+type flakyDB struct {
+	interfaces.Database
+	failures int
+	calls    int
+	verdicts []int
+}
+
+func (f *flakyDB) UpdateSolution(solutionID, result, timeUsed, memoryUsed int, passRate float64) error {
+	f.calls++
+	if f.calls <= f.failures {
+		return errors.New("driver: bad connection")
+	}
+	f.verdicts = append(f.verdicts, result)
+	return nil
+}
+
+func (f *flakyDB) GetSolution(solutionID int) (*Solution, error) {
+	f.calls++
+	return nil, fmt.Errorf("failed to get solution info: %w", sql.ErrNoRows)
+}
+
+func newTestRetryDB(db interfaces.Database) *retryDatabase {
+	return &retryDatabase{Database: db, attempts: 3, backoff: backoff.Backoff{Base: time.Millisecond, Max: time.Millisecond}}
+}
+
+func TestRetryDatabaseRidesOutOutage(t *testing.T) {
+	flaky := &flakyDB{failures: 2}
+	if err := newTestRetryDB(flaky).UpdateSolution(1, 4, 0, 0, 1); err != nil {
+		t.Fatalf("UpdateSolution = %v; want success on the third try", err)
+	}
+	if len(flaky.verdicts) != 1 || flaky.verdicts[0] != 4 {
+		t.Errorf("verdicts = %v; want [4]", flaky.verdicts)
+	}
+
+	flaky = &flakyDB{failures: 3}
+	if err := newTestRetryDB(flaky).UpdateSolution(1, 4, 0, 0, 1); err == nil {
+		t.Error("UpdateSolution succeeded although every attempt failed")
+	}
+}
+
+func TestRetryDatabaseDoesNotRetryMissingRows(t *testing.T) {
+	flaky := &flakyDB{}
+	if _, err := newTestRetryDB(flaky).GetSolution(1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetSolution = %v; want sql.ErrNoRows", err)
+	}
+	if flaky.calls != 1 {
+		t.Errorf("calls = %d; missing rows must not be retried", flaky.calls)
+	}
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+	"modernc.org/sqlite"
+)
+
+// SQLiteSchema creates the subset of the HUSTOJ tables the judge uses. It is
+// applied when a SQLite database is opened, so an empty file is a working
+// judge database. Timestamps are stored in UTC, like CURRENT_TIMESTAMP.
+const SQLiteSchema = `
+CREATE TABLE IF NOT EXISTS solution (
+	solution_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	problem_id  INTEGER NOT NULL DEFAULT 0,
+	user_id     VARCHAR(48) NOT NULL,
+	nick        VARCHAR(20) NOT NULL DEFAULT '',
+	time        INTEGER NOT NULL DEFAULT 0,
+	memory      INTEGER NOT NULL DEFAULT 0,
+	in_date     DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	result      INTEGER NOT NULL DEFAULT 0,
+	language    INTEGER NOT NULL DEFAULT 0,
+	ip          VARCHAR(46) NOT NULL DEFAULT '',
+	contest_id  INTEGER NOT NULL DEFAULT 0,
+	valid       INTEGER NOT NULL DEFAULT 1,
+	num         INTEGER NOT NULL DEFAULT -1,
+	code_length INTEGER NOT NULL DEFAULT 0,
+	judgetime   DATETIME,
+	pass_rate   DECIMAL(4,3) NOT NULL DEFAULT 0,
+	lint_error  INTEGER NOT NULL DEFAULT 0,
+	judger      CHAR(16) NOT NULL DEFAULT 'LOCAL'
+);
+CREATE INDEX IF NOT EXISTS solution_pending ON solution (result, language);
+CREATE TABLE IF NOT EXISTS source_code (
+	solution_id INTEGER PRIMARY KEY,
+	source      TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS problem (
+	problem_id   INTEGER PRIMARY KEY AUTOINCREMENT,
+	title        VARCHAR(200) NOT NULL DEFAULT '',
+	spj          CHAR(1) NOT NULL DEFAULT '0',
+	time_limit   DECIMAL(10,3) NOT NULL DEFAULT 1,
+	memory_limit INTEGER NOT NULL DEFAULT 128,
+	accepted     INTEGER NOT NULL DEFAULT 0,
+	submit       INTEGER NOT NULL DEFAULT 0,
+	solved       INTEGER NOT NULL DEFAULT 0,
+	defunct      CHAR(1) NOT NULL DEFAULT 'N'
+);
+CREATE TABLE IF NOT EXISTS compileinfo (
+	solution_id INTEGER PRIMARY KEY,
+	error       TEXT
+);
+CREATE TABLE IF NOT EXISTS runtimeinfo (
+	solution_id INTEGER PRIMARY KEY,
+	error       TEXT
+);
+CREATE TABLE IF NOT EXISTS users (
+	user_id VARCHAR(48) PRIMARY KEY,
+	nick    VARCHAR(20) NOT NULL DEFAULT '',
+	submit  INTEGER NOT NULL DEFAULT 0,
+	solved  INTEGER NOT NULL DEFAULT 0,
+	defunct CHAR(1) NOT NULL DEFAULT 'N'
+);
+CREATE TABLE IF NOT EXISTS contest (
+	contest_id   INTEGER PRIMARY KEY AUTOINCREMENT,
+	title        VARCHAR(255) NOT NULL DEFAULT '',
+	start_time   DATETIME,
+	end_time     DATETIME,
+	defunct      CHAR(1) NOT NULL DEFAULT 'N',
+	contest_type INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS solution_progress (
+	solution_id INTEGER PRIMARY KEY,
+	done        INTEGER NOT NULL DEFAULT 0,
+	total       INTEGER NOT NULL DEFAULT 0,
+	verdicts    TEXT NOT NULL DEFAULT '',
+	updated     DATETIME
+);
+CREATE TABLE IF NOT EXISTS contest_problem (
+	problem_id INTEGER NOT NULL DEFAULT 0,
+	contest_id INTEGER NOT NULL DEFAULT 0,
+	title      CHAR(200) NOT NULL DEFAULT '',
+	num        INTEGER NOT NULL DEFAULT 0,
+	c_accepted INTEGER NOT NULL DEFAULT 0,
+	c_submit   INTEGER NOT NULL DEFAULT 0
+);
+`
+
+// sqliteTimeFormat matches what CURRENT_TIMESTAMP stores.
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+func init() {
+	// The queries shared with MySQL call NOW(), which SQLite lacks.
+	sqlite.MustRegisterScalarFunction("now", 0, func(*sqlite.FunctionContext, []driver.Value) (driver.Value, error) {
+		return time.Now().UTC().Format(sqliteTimeFormat), nil
+	})
+}
+
+// OpenSQLite opens the SQLite database at path, creating the file and the
+// HUSTOJ tables if needed. The judge processes of one host share the file,
+// so writers wait for each other instead of failing with SQLITE_BUSY.
+func OpenSQLite(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	params := url.Values{"_pragma": {"busy_timeout(10000)", "journal_mode(WAL)"}, "_txlock": {"immediate"}}
+	db, err := sql.Open("sqlite", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(SQLiteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema: %w", err)
+	}
+	return db, nil
+}
+
+// NewSQLiteDatabase opens the SQLite database of cfg. The queries are the
+// same as for MySQL.
+func NewSQLiteDatabase(cfg *config.DatabaseConfig) (*Database, error) {
+	db, err := OpenSQLite(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", cfg.Path, err)
+	}
+	return &Database{db: db, judger: "go_judger"}, nil
+}
@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sempr/hustoj-go/pkg/config"
+)
+
+func TestSQLiteDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hustoj.db")
+	db, err := Open(&config.JudgeConfig{Judger: "j1", Database: config.DatabaseConfig{Driver: "sqlite", Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	raw := db.(*Database).db
+	for _, q := range []string{
+		"INSERT INTO problem (problem_id, time_limit, memory_limit, spj) VALUES (1000, 1.5, 256, '1')",
+		"INSERT INTO users (user_id) VALUES ('alice')",
+		"INSERT INTO solution (solution_id, problem_id, user_id, language, result, in_date) VALUES (1, 1000, 'alice', 1, 0, '2025-01-02 03:04:05')",
+		"INSERT INTO source_code VALUES (1, 'int main(){}')",
+	} {
+		if _, err := raw.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := db.GetSolution(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ProblemID != 1000 || s.UserID != "alice" || s.Language != 1 {
+		t.Errorf("solution = %+v", s)
+	}
+	if want := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC); !s.InDate.Equal(want) {
+		t.Errorf("in_date = %v; want %v", s.InDate, want)
+	}
+
+	p, err := db.GetProblem(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TimeLimit != 1.5 || p.MemLimit != 256 || p.SPJ != 1 {
+		t.Errorf("problem = %+v", p)
+	}
+
+	if src, err := db.GetSolutionSource(1); err != nil || src != "int main(){}" {
+		t.Errorf("source = %q, %v", src, err)
+	}
+
+	if err := db.UpdateSolution(1, 4, 12, 345, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateUserStats("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateProblemStats(1000, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.AddCompileError(1, "warning"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AddRuntimeInfo(1, "details"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var result int
+	var judger string
+	var judged time.Time
+	if err := raw.QueryRow("SELECT result, judger, judgetime FROM solution WHERE solution_id=1").Scan(&result, &judger, &judged); err != nil {
+		t.Fatal(err)
+	}
+	if result != 4 || judger != "j1" || time.Since(judged) > time.Minute {
+		t.Errorf("solution after update: result=%d judger=%q judgetime=%v", result, judger, judged)
+	}
+
+	var solved, accepted int
+	raw.QueryRow("SELECT solved FROM users WHERE user_id='alice'").Scan(&solved)
+	raw.QueryRow("SELECT accepted FROM problem WHERE problem_id=1000").Scan(&accepted)
+	if solved != 1 || accepted != 1 {
+		t.Errorf("solved = %d, accepted = %d; want 1, 1", solved, accepted)
+	}
+}
+
+func TestSQLiteProgress(t *testing.T) {
+	db, err := NewSQLiteDatabase(&config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "hustoj.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for done := 1; done <= 2; done++ {
+		if err := db.WriteProgress(Progress{SolutionID: 3, Done: done, Total: 5, Verdicts: []int{4, 6}[:done]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var done, total int
+	var verdicts string
+	if err := db.db.QueryRow("SELECT done, total, verdicts FROM solution_progress WHERE solution_id=3").Scan(&done, &total, &verdicts); err != nil {
+		t.Fatal(err)
+	}
+	if done != 2 || total != 5 || verdicts != "4,6" {
+		t.Errorf("progress = %d/%d %q; want 2/5 \"4,6\"", done, total, verdicts)
+	}
+
+	if err := db.ClearProgress(3); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	db.db.QueryRow("SELECT COUNT(*) FROM solution_progress").Scan(&n)
+	if n != 0 {
+		t.Errorf("%d progress rows left after ClearProgress", n)
+	}
+}